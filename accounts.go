@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	pb "github.com/decred/dcrwallet/rpc/walletrpc"
+)
+
+// walletAccounts returns the accounts of the wallet using the gRPC API.
+func walletAccounts(ctx context.Context, walletService pb.WalletServiceClient) ([]walletAccount, error) {
+	accountsResponse, err := walletService.Accounts(ctx, &pb.AccountsRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := make([]walletAccount, 0, len(accountsResponse.Accounts))
+	for _, account := range accountsResponse.Accounts {
+		accounts = append(accounts, walletAccount{
+			Number: account.AccountNumber,
+			Name:   account.AccountName,
+		})
+	}
+	return accounts, nil
+}
+
+// resolveAccounts resolves the source, change and voting account options,
+// each either an account name or number, against the accounts of the wallet.
+// The voting account is only resolved when purchasing tickets that vote with
+// an address of the wallet.
+func resolveAccounts(cfg *config, accounts []walletAccount) error {
+	source, err := resolveAccount("sourceaccount", cfg.SourceAccount, accounts)
+	if err != nil {
+		return err
+	}
+	cfg.sourceAccount = source.Number
+	cfg.sourceAccountName = source.Name
+
+	change, err := resolveAccount("changeaccount", cfg.ChangeAccount, accounts)
+	if err != nil {
+		return err
+	}
+	cfg.changeAccount = change.Number
+
+	if cfg.PurchaseTicket && !cfg.PoolMode && cfg.VotingXPub == "" {
+		voting, err := resolveAccount("votingaccount", cfg.VotingAccount, accounts)
+		if err != nil {
+			return err
+		}
+		cfg.votingAccount = voting.Number
+	}
+
+	return nil
+}
+
+// resolveAccount returns the account of accounts named by value, which is
+// either an account name or number.  A value that is the number of one
+// account and the name of another is rejected as ambiguous.
+func resolveAccount(flag, value string, accounts []walletAccount) (*walletAccount, error) {
+	var byName, byNumber *walletAccount
+	number, numErr := strconv.ParseUint(value, 10, 32)
+	for i := range accounts {
+		account := &accounts[i]
+		if account.Name == value {
+			byName = account
+		}
+		if numErr == nil && uint64(account.Number) == number {
+			byNumber = account
+		}
+	}
+
+	switch {
+	case byName != nil && byNumber != nil && byName.Number != byNumber.Number:
+		return nil, fmt.Errorf("--%s: %q is ambiguous, it is the name of "+
+			"account %d and the number of account %q", flag, value,
+			byName.Number, byNumber.Name)
+	case byName != nil:
+		return byName, nil
+	case byNumber != nil:
+		return byNumber, nil
+	default:
+		return nil, fmt.Errorf("--%s: no account named or numbered %q exists "+
+			"in the wallet", flag, value)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/dcrutil/v2"
+	pb "github.com/decred/dcrwallet/rpc/walletrpc"
+)
+
+// walletAccount is an account of the wallet.
+type walletAccount struct {
+	Number uint32
+	Name   string
+}
+
+// WalletBackend is the wallet functionality used to send transactions and
+// purchase tickets.
+type WalletBackend interface {
+	walletQuerier
+
+	// NextAddress derives the next external or internal address of
+	// account.
+	NextAddress(ctx context.Context, account uint32, internal bool) (dcrutil.Address, error)
+
+	// Accounts returns the accounts of the wallet.
+	Accounts(ctx context.Context) ([]walletAccount, error)
+
+	// BestBlock returns the hash and height of the wallet's main chain
+	// tip.
+	BestBlock(ctx context.Context) (*chainhash.Hash, int32, error)
+
+	// TicketPrice returns the price of a ticket in the next block.
+	TicketPrice(ctx context.Context) (dcrutil.Amount, error)
+
+	// SpendableBalance returns the spendable balance of account counting
+	// outputs with at least minConf confirmations.
+	SpendableBalance(ctx context.Context, account uint32, minConf int32) (dcrutil.Amount, error)
+
+	// SignTransaction signs the inputs of the serialized transaction tx
+	// and returns the serialized signed transaction.
+	SignTransaction(ctx context.Context, passphrase []byte, tx []byte) ([]byte, error)
+
+	// PublishTransaction publishes the serialized signed transaction tx.
+	PublishTransaction(ctx context.Context, tx []byte) (*chainhash.Hash, error)
+
+	// SignMessage signs message with the private key of address.
+	SignMessage(ctx context.Context, address dcrutil.Address, message string, passphrase []byte) ([]byte, error)
+
+	// Transaction returns the serialized wallet transaction with hash
+	// txHash.
+	Transaction(ctx context.Context, txHash *chainhash.Hash) ([]byte, error)
+
+	// TransactionNotifications subscribes to the wallet's transaction
+	// notifications until ctx is canceled.
+	TransactionNotifications(ctx context.Context) (notificationStream, error)
+}
+
+// notificationStream is a stream of the wallet's transaction notifications.
+type notificationStream interface {
+	Recv() (*pb.TransactionNotificationsResponse, error)
+}
+
+// walletClient is the WalletBackend of a dcrwallet process.  Queries are
+// answered by the configured walletQuerier, everything else uses the gRPC
+// API.
+type walletClient struct {
+	walletQuerier
+
+	walletService pb.WalletServiceClient
+	netParams     dcrutil.AddressParams
+}
+
+func newWalletClient(walletService pb.WalletServiceClient, querier walletQuerier, netParams dcrutil.AddressParams) *walletClient {
+	return &walletClient{
+		walletQuerier: querier,
+		walletService: walletService,
+		netParams:     netParams,
+	}
+}
+
+func (w *walletClient) NextAddress(ctx context.Context, account uint32, internal bool) (dcrutil.Address, error) {
+	addressRequest := &pb.NextAddressRequest{
+		Account:   account,
+		Kind:      pb.NextAddressRequest_BIP0044_EXTERNAL,
+		GapPolicy: pb.NextAddressRequest_GAP_POLICY_WRAP,
+	}
+
+	if internal {
+		addressRequest.Kind = pb.NextAddressRequest_BIP0044_INTERNAL
+	}
+
+	addressResponse, err := w.walletService.NextAddress(ctx, addressRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	return dcrutil.DecodeAddress(addressResponse.Address, w.netParams)
+}
+
+func (w *walletClient) Accounts(ctx context.Context) ([]walletAccount, error) {
+	return walletAccounts(ctx, w.walletService)
+}
+
+func (w *walletClient) BestBlock(ctx context.Context) (*chainhash.Hash, int32, error) {
+	bestBlockResponse, err := w.walletService.BestBlock(ctx, &pb.BestBlockRequest{})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	hash, err := chainhash.NewHash(bestBlockResponse.Hash)
+	if err != nil {
+		return nil, 0, err
+	}
+	return hash, int32(bestBlockResponse.Height), nil
+}
+
+func (w *walletClient) TicketPrice(ctx context.Context) (dcrutil.Amount, error) {
+	ticketPriceResponse, err := w.walletService.TicketPrice(ctx, &pb.TicketPriceRequest{})
+	if err != nil {
+		return 0, err
+	}
+
+	return dcrutil.Amount(ticketPriceResponse.TicketPrice), nil
+}
+
+func (w *walletClient) SpendableBalance(ctx context.Context, account uint32, minConf int32) (dcrutil.Amount, error) {
+	balanceRequest := &pb.BalanceRequest{
+		AccountNumber:         account,
+		RequiredConfirmations: minConf,
+	}
+	balanceResponse, err := w.walletService.Balance(ctx, balanceRequest)
+	if err != nil {
+		return 0, err
+	}
+
+	return dcrutil.Amount(balanceResponse.Spendable), nil
+}
+
+func (w *walletClient) SignTransaction(ctx context.Context, passphrase []byte, tx []byte) ([]byte, error) {
+	// The request is given its own copy of the passphrase which is cleared
+	// as soon as the request has been sent.
+	passphraseCopy := make([]byte, len(passphrase))
+	copy(passphraseCopy, passphrase)
+	signTransactionRequest := &pb.SignTransactionRequest{
+		Passphrase:            passphraseCopy,
+		SerializedTransaction: tx,
+	}
+
+	signTransactionResponse, err := w.walletService.SignTransaction(ctx, signTransactionRequest)
+	zeroBytes(passphraseCopy)
+	if err != nil {
+		return nil, err
+	}
+
+	return signTransactionResponse.Transaction, nil
+}
+
+func (w *walletClient) PublishTransaction(ctx context.Context, tx []byte) (*chainhash.Hash, error) {
+	publishTransactionRequest := &pb.PublishTransactionRequest{
+		SignedTransaction: tx,
+	}
+
+	publishTransactionResponse, err := w.walletService.PublishTransaction(ctx, publishTransactionRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	return chainhash.NewHash(publishTransactionResponse.TransactionHash)
+}
+
+func (w *walletClient) SignMessage(ctx context.Context, address dcrutil.Address, message string, passphrase []byte) ([]byte, error) {
+	passphraseCopy := make([]byte, len(passphrase))
+	copy(passphraseCopy, passphrase)
+	signMessageRequest := &pb.SignMessageRequest{
+		Address:    address.Address(),
+		Message:    message,
+		Passphrase: passphraseCopy,
+	}
+
+	signMessageResponse, err := w.walletService.SignMessage(ctx, signMessageRequest)
+	zeroBytes(passphraseCopy)
+	if err != nil {
+		return nil, err
+	}
+
+	return signMessageResponse.Signature, nil
+}
+
+func (w *walletClient) Transaction(ctx context.Context, txHash *chainhash.Hash) ([]byte, error) {
+	transactionRequest := &pb.GetTransactionRequest{
+		TransactionHash: txHash[:],
+	}
+
+	transactionResponse, err := w.walletService.GetTransaction(ctx, transactionRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	return transactionResponse.Transaction.Transaction, nil
+}
+
+func (w *walletClient) TransactionNotifications(ctx context.Context) (notificationStream, error) {
+	return w.walletService.TransactionNotifications(ctx, &pb.TransactionNotificationsRequest{})
+}
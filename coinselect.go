@@ -0,0 +1,432 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/decred/dcrd/dcrutil/v2"
+	"github.com/decred/dcrd/txscript/v2"
+	"github.com/decred/dcrd/wire"
+	"github.com/decred/dcrwallet/errors/v2"
+	"github.com/decred/dcrwallet/wallet/v3/txrules"
+	"github.com/decred/dcrwallet/wallet/v3/txsizes"
+)
+
+const (
+	coinSelectRandom       = "random"
+	coinSelectLargestFirst = "largestfirst"
+	coinSelectSmallest     = "smallestfirst"
+	coinSelectExactMatch   = "exactmatch"
+	coinSelectSingleInput  = "singleinput"
+
+	// maxBranchAndBoundTries bounds the number of branches visited by the
+	// exact-match search.
+	maxBranchAndBoundTries = 100000
+)
+
+// coin is an unspent output along with the size of the signature script
+// that redeems it.
+type coin struct {
+	*unspentOutput
+	redeemScriptSize int
+}
+
+// selectionTarget describes what the coins selected for a transaction must
+// pay for.
+type selectionTarget struct {
+	// amount is the total value of the outputs, excluding change.
+	amount dcrutil.Amount
+
+	// outputs are the outputs of the transaction, excluding change.
+	outputs []*wire.TxOut
+
+	// changeScriptSize is the size of the change output script.
+	changeScriptSize int
+
+	// relayFee is the fee rate per kB.
+	relayFee dcrutil.Amount
+}
+
+// fee returns the fee of a transaction spending coins, with a change output
+// when change is set.
+func (t *selectionTarget) fee(coins []*coin, change bool) dcrutil.Amount {
+	return txrules.FeeForSerializeSize(t.relayFee, t.size(coins, change))
+}
+
+// size returns the estimated size of the signed transaction spending coins,
+// with a change output when change is set.
+func (t *selectionTarget) size(coins []*coin, change bool) int {
+	scriptSizes := make([]int, 0, len(coins))
+	for _, c := range coins {
+		scriptSizes = append(scriptSizes, c.redeemScriptSize)
+	}
+
+	var changeScriptSize int
+	if change {
+		changeScriptSize = t.changeScriptSize
+	}
+	return txsizes.EstimateSerializeSize(scriptSizes, t.outputs, changeScriptSize)
+}
+
+// covered returns whether coins pay for the outputs and the fee of a
+// transaction without change.
+func (t *selectionTarget) covered(coins []*coin) bool {
+	return sumCoins(coins) >= t.amount+t.fee(coins, false)
+}
+
+// CoinSelector chooses the coins funding a transaction.
+type CoinSelector interface {
+	// SelectCoins returns coins whose total value pays for target's
+	// outputs and the fee of spending them.  Selected coins beyond this
+	// amount go to change.
+	SelectCoins(coins []*coin, target *selectionTarget) ([]*coin, error)
+}
+
+// newCoinSelector returns the coin selection strategy with the given name.
+func newCoinSelector(name string) (CoinSelector, error) {
+	switch name {
+	case coinSelectRandom:
+		return randomSelector{}, nil
+	case coinSelectLargestFirst:
+		return largestFirstSelector{}, nil
+	case coinSelectSmallest:
+		return smallestFirstSelector{}, nil
+	case coinSelectExactMatch:
+		return newExactMatchSelector(0, 0, randomSelector{}), nil
+	case coinSelectSingleInput:
+		return singleInputSelector{fallback: largestFirstSelector{}}, nil
+	}
+
+	names := []string{coinSelectRandom, coinSelectLargestFirst, coinSelectSmallest,
+		coinSelectExactMatch, coinSelectSingleInput}
+	return nil, fmt.Errorf("unknown coin selection strategy %q, must be one of %s",
+		name, strings.Join(names, ", "))
+}
+
+// spendableCoins returns the unspent outputs that can be signed for as coins.
+func spendableCoins(unspentOutputs []*unspentOutput) []*coin {
+	coins := make([]*coin, 0, len(unspentOutputs))
+	for _, unspentOutput := range unspentOutputs {
+		pkScript := unspentOutput.PkScript
+
+		scriptClass := txscript.GetScriptClass(0, pkScript)
+		var scriptSize int
+
+		switch scriptClass {
+		case txscript.PubKeyHashTy:
+			scriptSize = txsizes.RedeemP2PKHSigScriptSize
+		case txscript.PubKeyTy:
+			scriptSize = txsizes.RedeemP2PKSigScriptSize
+		case txscript.StakeRevocationTy, txscript.StakeSubChangeTy, txscript.StakeGenTy:
+			var err error
+			scriptClass, err = txscript.GetStakeOutSubclass(pkScript)
+			if err != nil {
+				fmt.Printf("failed to extract nested script in stake output: %v\n",
+					err)
+				continue
+			}
+
+			// For stake transactions we expect P2PKH and P2SH script class
+			// types only but ignore P2SH script type since it can pay
+			// to any script which the wallet may not recognize.
+			if scriptClass != txscript.PubKeyHashTy {
+				fmt.Printf("unexpected nested script class for credit: %v\n",
+					scriptClass)
+				continue
+			}
+
+			scriptSize = txsizes.RedeemP2PKHSigScriptSize
+		default:
+			fmt.Printf("unexpected script class for credit: %v\n",
+				scriptClass)
+			continue
+		}
+
+		coins = append(coins, &coin{
+			unspentOutput:    unspentOutput,
+			redeemScriptSize: scriptSize,
+		})
+	}
+
+	return coins
+}
+
+func sumCoins(coins []*coin) dcrutil.Amount {
+	var total dcrutil.Amount
+	for _, c := range coins {
+		total += c.Amount
+	}
+	return total
+}
+
+// accumulateCoins selects coins in order until they cover target.
+func accumulateCoins(coins []*coin, target *selectionTarget) ([]*coin, error) {
+	var selected []*coin
+	for _, c := range coins {
+		selected = append(selected, c)
+		if target.covered(selected) {
+			return selected, nil
+		}
+	}
+
+	return nil, errors.E(errors.InsufficientBalance)
+}
+
+var (
+	shuffleRand    = rand.New(rand.NewSource(time.Now().UnixNano()))
+	shuffleRandMtx sync.Mutex
+)
+
+// randomSelector selects coins in random order until the target is covered.
+type randomSelector struct{}
+
+func (randomSelector) SelectCoins(coins []*coin, target *selectionTarget) ([]*coin, error) {
+	shuffled := append([]*coin(nil), coins...)
+
+	shuffleRandMtx.Lock()
+	shuffleRand.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	shuffleRandMtx.Unlock()
+
+	return accumulateCoins(shuffled, target)
+}
+
+// largestFirstSelector selects the largest coins first, which minimizes the
+// number of inputs.
+type largestFirstSelector struct{}
+
+func (largestFirstSelector) SelectCoins(coins []*coin, target *selectionTarget) ([]*coin, error) {
+	sorted := append([]*coin(nil), coins...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Amount > sorted[j].Amount
+	})
+
+	return accumulateCoins(sorted, target)
+}
+
+// smallestFirstSelector selects the smallest coins first, which consolidates
+// small outputs at the cost of larger transactions.
+type smallestFirstSelector struct{}
+
+func (smallestFirstSelector) SelectCoins(coins []*coin, target *selectionTarget) ([]*coin, error) {
+	sorted := append([]*coin(nil), coins...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Amount < sorted[j].Amount
+	})
+
+	return accumulateCoins(sorted, target)
+}
+
+// exactMatchSelector searches for coins that cover the target with an excess
+// of at most tolerance, using a branch and bound search, so no change output
+// is needed and the excess is added to the fee.  Matches whose fee, including
+// the excess, exceeds maxFeePercent of the target amount are rejected.  The
+// fallback strategy is used when no such set of coins exists.  It counts how
+// many selections found an exact match.
+type exactMatchSelector struct {
+	fallback      CoinSelector
+	tolerance     dcrutil.Amount
+	maxFeePercent float64
+
+	mtx        sync.Mutex
+	selections int
+	matches    int
+}
+
+// newExactMatchSelector returns an exactMatchSelector accepting an excess of
+// up to tolerance.  A zero tolerance accepts an excess below the cost of a
+// change output, a zero maxFeePercent does not limit the fee.
+func newExactMatchSelector(tolerance dcrutil.Amount, maxFeePercent float64, fallback CoinSelector) *exactMatchSelector {
+	return &exactMatchSelector{
+		fallback:      fallback,
+		tolerance:     tolerance,
+		maxFeePercent: maxFeePercent,
+	}
+}
+
+// maxExcess returns the largest excess value accepted for target.  An excess
+// of the change cost already pays for a change output that is not dust, so it
+// is not accepted without a tolerance.
+func (s *exactMatchSelector) maxExcess(target *selectionTarget) dcrutil.Amount {
+	if s.tolerance > 0 {
+		return s.tolerance
+	}
+	return changeCost(target) - 1
+}
+
+// maxFee returns the largest fee accepted for target, or 0 without a limit.
+func (s *exactMatchSelector) maxFee(target *selectionTarget) dcrutil.Amount {
+	if s.maxFeePercent == 0 {
+		return 0
+	}
+	return dcrutil.Amount(float64(target.amount) * s.maxFeePercent / 100)
+}
+
+func (s *exactMatchSelector) SelectCoins(coins []*coin, target *selectionTarget) ([]*coin, error) {
+	selected := branchAndBound(coins, target, s.maxExcess(target), s.maxFee(target))
+
+	s.mtx.Lock()
+	s.selections++
+	if selected != nil {
+		s.matches++
+	}
+	s.mtx.Unlock()
+
+	if selected != nil {
+		return selected, nil
+	}
+
+	return s.fallback.SelectCoins(coins, target)
+}
+
+// stats returns the number of selections made and how many of them found an
+// exact match.
+func (s *exactMatchSelector) stats() (selections, matches int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.selections, s.matches
+}
+
+// branchAndBound returns coins covering target with an excess of at most
+// maxExcess and a fee of at most maxFee, or nil when no such coins were
+// found.  A zero maxFee does not limit the fee.
+func branchAndBound(coins []*coin, target *selectionTarget, maxExcess, maxFee dcrutil.Amount) []*coin {
+	// The search works with effective values, which are the coin values
+	// less the fee of spending them, so the fee of any set of coins
+	// follows from the fee of the transaction without inputs.
+	type candidate struct {
+		coin           *coin
+		effectiveValue dcrutil.Amount
+	}
+
+	baseSize := target.size(nil, false)
+	candidates := make([]candidate, 0, len(coins))
+	var available dcrutil.Amount
+	for _, c := range coins {
+		inputSize := target.size([]*coin{c}, false) - baseSize
+		inputFee := txrules.FeeForSerializeSize(target.relayFee, inputSize)
+		effectiveValue := c.Amount - inputFee
+		if effectiveValue <= 0 {
+			continue
+		}
+		candidates = append(candidates, candidate{c, effectiveValue})
+		available += effectiveValue
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].effectiveValue > candidates[j].effectiveValue
+	})
+
+	lower := target.amount + target.fee(nil, false)
+	upper := lower + maxExcess
+	if available < lower {
+		return nil
+	}
+
+	var (
+		tries    int
+		included = make([]bool, len(candidates))
+		best     []*coin
+	)
+
+	var search func(depth int, total, remaining dcrutil.Amount) bool
+	search = func(depth int, total, remaining dcrutil.Amount) bool {
+		tries++
+		if tries > maxBranchAndBoundTries || total > upper ||
+			total+remaining < lower {
+			return false
+		}
+
+		if total >= lower {
+			selected := make([]*coin, 0, depth)
+			for i := 0; i < depth; i++ {
+				if included[i] {
+					selected = append(selected, candidates[i].coin)
+				}
+			}
+
+			// Fee estimates of individual inputs may round
+			// differently than the estimate of the whole
+			// transaction, so verify the match.
+			if !target.covered(selected) {
+				return false
+			}
+			excess := sumCoins(selected) - target.amount -
+				target.fee(selected, false)
+			if excess > maxExcess {
+				return false
+			}
+			if maxFee != 0 && sumCoins(selected)-target.amount > maxFee {
+				return false
+			}
+			best = selected
+			return true
+		}
+
+		if depth == len(candidates) {
+			return false
+		}
+
+		value := candidates[depth].effectiveValue
+		included[depth] = true
+		if search(depth+1, total+value, remaining-value) {
+			return true
+		}
+		included[depth] = false
+		return search(depth+1, total, remaining-value)
+	}
+
+	search(0, 0, available)
+	return best
+}
+
+// changeCost returns the value that is lost to fees when a change output is
+// omitted: the fee of adding the change output plus the smallest change
+// amount that is not dust.
+func changeCost(target *selectionTarget) dcrutil.Amount {
+	changeOutputSize := target.size(nil, true) - target.size(nil, false)
+	changeOutputFee := txrules.FeeForSerializeSize(target.relayFee, changeOutputSize)
+
+	// Search for the smallest non-dust amount, IsDustAmount is monotonic
+	// in the amount.
+	low, high := dcrutil.Amount(0), dcrutil.Amount(dcrutil.AtomsPerCoin)
+	for low < high {
+		mid := (low + high) / 2
+		if txrules.IsDustAmount(mid, target.changeScriptSize, target.relayFee) {
+			low = mid + 1
+		} else {
+			high = mid
+		}
+	}
+
+	return changeOutputFee + low
+}
+
+// singleInputSelector selects the smallest coin that covers the target on
+// its own, so the transaction does not link several outputs of the wallet.
+// The fallback strategy is used when no single coin is large enough.
+type singleInputSelector struct {
+	fallback CoinSelector
+}
+
+func (s singleInputSelector) SelectCoins(coins []*coin, target *selectionTarget) ([]*coin, error) {
+	var best *coin
+	for _, c := range coins {
+		if !target.covered([]*coin{c}) {
+			continue
+		}
+		if best == nil || c.Amount < best.Amount {
+			best = c
+		}
+	}
+
+	if best != nil {
+		return []*coin{best}, nil
+	}
+
+	return s.fallback.SelectCoins(coins, target)
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/dcrutil/v2"
+	"github.com/decred/dcrd/wire"
+	"github.com/decred/dcrwallet/wallet/v3/txrules"
+	"github.com/decred/dcrwallet/wallet/v3/txsizes"
+)
+
+const testRelayFee = 1e4
+
+// testCoins returns P2PKH coins of the given amounts, each with a unique
+// outpoint.
+func testCoins(amounts ...dcrutil.Amount) []*coin {
+	coins := make([]*coin, 0, len(amounts))
+	for i, amount := range amounts {
+		var hash chainhash.Hash
+		binary.LittleEndian.PutUint32(hash[:], uint32(i))
+		coins = append(coins, &coin{
+			unspentOutput: &unspentOutput{
+				OutPoint: *wire.NewOutPoint(&hash, 0, wire.TxTreeRegular),
+				Amount:   amount,
+			},
+			redeemScriptSize: txsizes.RedeemP2PKHSigScriptSize,
+		})
+	}
+	return coins
+}
+
+// testTarget returns the target of a transaction paying amount to a single
+// P2PKH output.
+func testTarget(amount dcrutil.Amount) *selectionTarget {
+	pkScript := make([]byte, txsizes.P2PKHPkScriptSize)
+	return &selectionTarget{
+		amount:           amount,
+		outputs:          []*wire.TxOut{wire.NewTxOut(int64(amount), pkScript)},
+		changeScriptSize: txsizes.P2PKHPkScriptSize,
+		relayFee:         testRelayFee,
+	}
+}
+
+// selectionWaste returns the value of coins that is lost to fees: the fee of
+// the transaction with change, or all of the excess when the change would be
+// dust.
+func selectionWaste(coins []*coin, target *selectionTarget) dcrutil.Amount {
+	excess := sumCoins(coins) - target.amount
+	fee := target.fee(coins, true)
+	if txrules.IsDustAmount(excess-fee, target.changeScriptSize, target.relayFee) {
+		return excess
+	}
+	return fee
+}
+
+// exactAmounts returns coin amounts of which the last two cover target
+// without change and with an excess of extra.
+func exactAmounts(target *selectionTarget, extra dcrutil.Amount, others ...dcrutil.Amount) []dcrutil.Amount {
+	const first = 3 * dcrutil.AtomsPerCoin
+	fee := target.fee(testCoins(0, 0), false)
+	second := target.amount + fee + extra - first
+	return append(others, first, second)
+}
+
+// checkSelection checks that selected are distinct coins of coins that cover
+// target.
+func checkSelection(t *testing.T, name string, coins, selected []*coin, target *selectionTarget) {
+	t.Helper()
+
+	known := make(map[*coin]bool, len(coins))
+	for _, c := range coins {
+		known[c] = true
+	}
+	for _, c := range selected {
+		if !known[c] {
+			t.Errorf("%s: selected an unknown or duplicate coin", name)
+		}
+		delete(known, c)
+	}
+	if !target.covered(selected) {
+		t.Errorf("%s: selected %s does not cover %s plus fee %s", name,
+			sumCoins(selected), target.amount, target.fee(selected, false))
+	}
+}
+
+func testSelectors() map[string]CoinSelector {
+	return map[string]CoinSelector{
+		coinSelectRandom:       randomSelector{},
+		coinSelectLargestFirst: largestFirstSelector{},
+		coinSelectSmallest:     smallestFirstSelector{},
+		coinSelectExactMatch:   newExactMatchSelector(0, 0, randomSelector{}),
+		coinSelectSingleInput:  singleInputSelector{fallback: largestFirstSelector{}},
+	}
+}
+
+func TestSelectCoins(t *testing.T) {
+	target := testTarget(5 * dcrutil.AtomsPerCoin)
+
+	tests := []struct {
+		name    string
+		amounts []dcrutil.Amount
+		wantErr bool
+
+		// wantInputs are the expected number of inputs selected by the
+		// deterministic strategies.
+		wantInputs map[string]int
+
+		// leastWaste are the strategies that must waste no more than
+		// any other strategy.
+		leastWaste []string
+	}{{
+		name: "mixed",
+		amounts: []dcrutil.Amount{1e7, 2e8, 4e8, 6e8, 1e9, 3e7,
+			5e8 + 1e6},
+		wantInputs: map[string]int{
+			coinSelectLargestFirst: 1,
+			coinSelectSmallest:     4,
+			coinSelectSingleInput:  1,
+		},
+	}, {
+		name:    "exact match",
+		amounts: exactAmounts(target, 0, 2e8, 4e7),
+		wantInputs: map[string]int{
+			coinSelectLargestFirst: 2,
+			coinSelectSmallest:     4,
+			coinSelectExactMatch:   2,
+		},
+		leastWaste: []string{coinSelectExactMatch},
+	}, {
+		name:    "no single coin",
+		amounts: []dcrutil.Amount{2e8, 2e8, 2e8, 1e7},
+		wantInputs: map[string]int{
+			coinSelectLargestFirst: 3,
+			coinSelectSingleInput:  3,
+		},
+	}, {
+		name:    "insufficient funds",
+		amounts: []dcrutil.Amount{2e8, 2e8, 1e8},
+		wantErr: true,
+	}, {
+		name:    "exact amount without fee",
+		amounts: []dcrutil.Amount{5e8},
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			coins := testCoins(test.amounts...)
+			waste := make(map[string]dcrutil.Amount)
+			for name, selector := range testSelectors() {
+				selected, err := selector.SelectCoins(coins, target)
+				if test.wantErr {
+					if err == nil {
+						t.Errorf("%s: expected an error", name)
+					}
+					continue
+				}
+				if err != nil {
+					t.Errorf("%s: %v", name, err)
+					continue
+				}
+
+				checkSelection(t, name, coins, selected, target)
+				if want, ok := test.wantInputs[name]; ok && len(selected) != want {
+					t.Errorf("%s: selected %d inputs, want %d", name,
+						len(selected), want)
+				}
+				waste[name] = selectionWaste(selected, target)
+			}
+
+			for _, name := range test.leastWaste {
+				for other, otherWaste := range waste {
+					if waste[name] > otherWaste {
+						t.Errorf("%s wastes %s, more than %s wasting %s", name,
+							waste[name], other, otherWaste)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestExactMatchSelector(t *testing.T) {
+	target := testTarget(5 * dcrutil.AtomsPerCoin)
+	cost := changeCost(target)
+
+	tests := []struct {
+		name          string
+		extra         dcrutil.Amount
+		tolerance     dcrutil.Amount
+		maxFeePercent float64
+		wantMatch     bool
+	}{{
+		name:      "exact",
+		wantMatch: true,
+	}, {
+		name:      "excess below change cost",
+		extra:     cost - 1,
+		wantMatch: true,
+	}, {
+		name:  "excess at change cost",
+		extra: cost,
+	}, {
+		name:  "excess above change cost",
+		extra: cost + 1e5,
+	}, {
+		name:      "excess within tolerance",
+		extra:     cost + 1e5,
+		tolerance: cost + 2e5,
+		wantMatch: true,
+	}, {
+		name:      "excess above tolerance",
+		extra:     1e6,
+		tolerance: 1e5,
+	}, {
+		name:          "fee within max fee percent",
+		extra:         cost - 1,
+		maxFeePercent: 1,
+		wantMatch:     true,
+	}, {
+		name:          "fee above max fee percent",
+		extra:         cost - 1,
+		maxFeePercent: 0.001,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The other coins are either far too small or far too
+			// large to be part of a match.
+			coins := testCoins(exactAmounts(target, test.extra, 1e4, 20e8)...)
+			selector := newExactMatchSelector(test.tolerance, test.maxFeePercent,
+				largestFirstSelector{})
+
+			selected, err := selector.SelectCoins(coins, target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSelection(t, coinSelectExactMatch, coins, selected, target)
+
+			selections, matches := selector.stats()
+			if selections != 1 || (matches == 1) != test.wantMatch {
+				t.Fatalf("%d selections with %d matches, want match %v",
+					selections, matches, test.wantMatch)
+			}
+			if !test.wantMatch {
+				// The fallback selects the largest coin.
+				if len(selected) != 1 || selected[0].Amount != 20e8 {
+					t.Errorf("fallback selected %d coins", len(selected))
+				}
+				return
+			}
+
+			if len(selected) != 2 {
+				t.Fatalf("selected %d coins, want the 2 matching coins", len(selected))
+			}
+			excess := sumCoins(selected) - target.amount - target.fee(selected, false)
+			if excess != test.extra {
+				t.Errorf("excess %s, want %s", excess, test.extra)
+			}
+
+			// Without a tolerance the excess never pays for change
+			// that is not dust.
+			change := sumCoins(selected) - target.amount - target.fee(selected, true)
+			if test.tolerance == 0 && change > 0 && !txrules.IsDustAmount(change,
+				target.changeScriptSize, target.relayFee) {
+				t.Errorf("match leaves change of %s", change)
+			}
+		})
+	}
+}
+
+func TestSingleInputSelector(t *testing.T) {
+	target := testTarget(5 * dcrutil.AtomsPerCoin)
+	coins := testCoins(20e8, 6e8, 5e8+1e6, 1e8)
+
+	selected, err := singleInputSelector{fallback: largestFirstSelector{}}.SelectCoins(coins, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSelection(t, coinSelectSingleInput, coins, selected, target)
+	if len(selected) != 1 || selected[0].Amount != 5e8+1e6 {
+		t.Fatalf("did not select the smallest coin covering the target")
+	}
+}
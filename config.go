@@ -1,62 +1,192 @@
 package main
 
 import (
+	"crypto/ed25519"
+	"encoding/base64"
 	"errors"
 	"fmt"
-	"github.com/decred/dcrd/chaincfg/v2"
 	"github.com/decred/dcrd/dcrutil/v2"
+	"github.com/decred/dcrwallet/wallet/v3/txrules"
 	"os"
+	"path/filepath"
+	"time"
 
 	flags "github.com/jessevdk/go-flags"
 )
 
 const (
-	defaultNetwork = "testnet3"
-
-	defaultSourceAccount uint32 = 0 // mixed account
-	defaultChangeAccount uint32 = 1 // unmixed account
-	defaultVotingAccount uint32 = 2
-
-	defaultGRPCServer    = "localhost:19111"
-	defaultGPRCPort      = "19111"
-	defaultJSONRPCServer = "localhost:19110"
-	defaultJSONRPCPort   = "19110"
-	defaultRPCUser       = "dcrwallet"
-	defaultRPCPass       = "dcrwallet"
+	defaultConfigFilename = "ticketbuyer.conf"
+	defaultNetwork        = "testnet3"
+
+	defaultSourceAccount = "0" // mixed account
+	defaultChangeAccount = "1" // unmixed account
+	defaultVotingAccount = "2"
+
+	defaultNumTickets  = 1
+	defaultMaxPerBlock = 1
+	defaultMinConf     = 1
+
+	defaultAverageWindows   = 8
+	defaultTicketsPerWindow = 1
+
+	// defaultSpendPeriod is the default rolling period limited by
+	// --maxspend.
+	defaultSpendPeriod = 24 * time.Hour
+
+	// defaultWindowMargin is the default number of final blocks of a
+	// stake difficulty window in which no purchases are started.
+	defaultWindowMargin = 2
+
+	defaultRPCHost = "localhost"
+	defaultRPCUser = "dcrwallet"
+	defaultRPCPass = "dcrwallet"
+
+	defaultRPCTimeout = 30 * time.Second
+
+	// defaultRelayFee is the default fee rate in DCR/kB used with
+	// --grpconly, matching the wallet's default relay fee.
+	defaultRelayFee = 0.0001
+
+	// defaultVSPMaxFee is the default largest VSP fee in DCR paid for a
+	// ticket.
+	defaultVSPMaxFee = 0.5
+
+	// maxFundingTolerance is the largest --fundingtolerance in DCR, a
+	// small multiple of the cost of a change output, so funding
+	// transactions never add more than dust to the fee.
+	maxFundingTolerance = 0.001
+)
+
+var (
+	defaultAppDataDir = dcrutil.AppDataDir("ticketbuyer", false)
+	defaultConfigFile = filepath.Join(defaultAppDataDir, defaultConfigFilename)
+	defaultRPCCert    = filepath.Join(dcrutil.AppDataDir("dcrwallet", false), "rpc.cert")
 )
 
 type config struct {
-	Network            string  `long:"network" description:"specify network to use"`
-	SendTx             bool    `long:"sendtx" description:"send regular transaction using randomixed utxos"`
-	DestinationAddress string  `long:"destaddr" description:"must be used with --sendtx"`
-	SendAmount         float64 `long:"amount" description:"must be used with --sendtx"`
-	PurchaseTicket     bool    `long:"purchaseticket"`
-	SpendUnconfirmed   bool    `long:"spendunconfirmed" description:"allow use of unconfirmed utxos"`
-	SourceAccountName  string  `long:"sourceaccountname" description:"account name for same account passed as --sourceaccount"`
-	SourceAccount      uint32  `long:"sourceaccount" description:"account used to send funds using randomized inputs and also used to derive fresh addresses from for mixed ticket splits"`
-	ChangeAccount      uint32  `long:"changeaccount" description:"account used as change output in regular transactions and also used to derive unmixed CoinJoin outputs"`
-	VotingAccount      uint32  `long:"votingaccount" description:"account used to derive addresses specifying voting rights"`
-	GRPCServer         string  `long:"grpcserver" description:"Wallet GRPC server to connect to"`
-	RPCServer          string  `long:"rpcserver" description:"Wallet RPC server to connect to"`
-	RPCUser            string  `long:"rpcuser" description:"JSON-RPC username and default dcrwallet GRPC username"`
-	RPCPass            string  `long:"rpcPass" description:"JSON-RPC password and default dcrwallet GRPC password"`
-	WalletPassphrase   string  `long:"walletpass" description:"Wallet passphrase"`
+	ConfigFile         string        `short:"C" long:"configfile" description:"Path to configuration file"`
+	Network            string        `long:"network" description:"specify network to use (mainnet, testnet3, simnet or regnet)"`
+	SendTx             bool          `long:"sendtx" description:"send regular transaction using randomixed utxos"`
+	DestinationAddress string        `long:"destaddr" description:"must be used with --sendtx"`
+	SendAmount         float64       `long:"amount" description:"must be used with --sendtx"`
+	PurchaseTicket     bool          `long:"purchaseticket"`
+	StakeInfo          bool          `long:"stakeinfo" description:"print the position in the stake difficulty window and the estimated ticket price of the next window"`
+	NumTickets         int           `long:"numtickets" description:"number of tickets to purchase with a single split transaction, must be used with --purchaseticket"`
+	Daemon             bool          `long:"daemon" description:"keep running and consider a ticket purchase for every attached block, must be used with --purchaseticket"`
+	BalanceToMaintain  float64       `long:"balancetomaintain" description:"spendable source account balance in DCR that daemon purchases must leave untouched"`
+	MaxPerBlock        int           `long:"maxperblock" description:"maximum number of tickets purchased per attached block in daemon mode"`
+	MaxPrice           float64       `long:"maxprice" description:"do not purchase tickets in daemon mode while the ticket price in DCR is above this value, 0 disables the limit, used with --strategy=priceceiling"`
+	Strategy           string        `long:"strategy" description:"daemon buying strategy (priceceiling, movingaverage, maintainbalance or perwindow)"`
+	AverageWindows     int           `long:"averagewindows" description:"number of previous stake difficulty windows averaged by --strategy=movingaverage"`
+	TicketsPerWindow   int           `long:"ticketsperwindow" description:"number of tickets purchased in every stake difficulty window by --strategy=perwindow"`
+	CoinSelect         string        `long:"coinselect" description:"coin selection strategy for regular and split transactions (random, largestfirst, smallestfirst, exactmatch or singleinput)"`
+	FundingTolerance   float64       `long:"fundingtolerance" description:"largest excess in DCR over the ticket cost that funding transactions add to the fee instead of creating a change output, at most 0.001, 0 uses the cost of a change output"`
+	DryRun             bool          `long:"dryrun" description:"build and print transactions without signing or publishing them"`
+	SpendUnconfirmed   bool          `long:"spendunconfirmed" description:"allow use of unconfirmed utxos, overrides --minconf"`
+	MinConf            int32         `long:"minconf" description:"minimum number of confirmations of source account outputs spent by regular and funding transactions"`
+	FundingConfs       int32         `long:"fundingconfs" description:"number of confirmations of the funding transaction required before tickets are built, 0 builds tickets spending the unconfirmed funding transaction"`
+	MaxVoteFee         string        `long:"maxvotefee" description:"largest fee in DCR, or 2^N atoms, the vote of a ticket may pay out of its commitments, 0 allows no fee"`
+	MaxRevokeFee       string        `long:"maxrevokefee" description:"largest fee in DCR, or 2^N atoms, the revocation of a ticket may pay out of its commitments, 0 allows no fee"`
+	TicketExpiry       int32         `long:"ticketexpiry" description:"number of blocks a ticket can be mined in before it expires, 0 expires tickets at the end of the current stake difficulty window"`
+	TxExpiry           int32         `long:"txexpiry" description:"number of blocks regular and funding transactions can be mined in before they expire, 0 disables expiry"`
+	WindowMargin       int32         `long:"windowmargin" description:"number of final blocks of a stake difficulty window, in addition to --fundingconfs, in which no ticket purchases are started"`
+	SourceAccount      string        `long:"sourceaccount" description:"name or number of the account used to send funds using randomized inputs and also used to derive fresh addresses from for mixed ticket splits"`
+	ChangeAccount      string        `long:"changeaccount" description:"name or number of the account used as change output in regular transactions and also used to derive unmixed CoinJoin outputs"`
+	VotingAccount      string        `long:"votingaccount" description:"name or number of the account used to derive addresses specifying voting rights"`
+	VotingXPub         string        `long:"votingxpub" description:"account extended public key of a separate voting wallet to derive addresses specifying voting rights from instead of --votingaccount"`
+	GRPCServer         string        `long:"grpcserver" description:"Wallet GRPC server to connect to, defaults to localhost on the network's default port"`
+	RPCServer          string        `long:"rpcserver" description:"Wallet RPC server to connect to, defaults to localhost on the network's default port"`
+	GRPCOnly           bool          `long:"grpconly" description:"only use the GRPC API of the wallet, the JSON-RPC server options are ignored"`
+	TicketFee          float64       `long:"ticketfee" description:"ticket fee rate in DCR/kB used with --grpconly"`
+	TxFee              float64       `long:"txfee" description:"regular transaction fee rate in DCR/kB used with --grpconly"`
+	RPCCert            string        `long:"rpccert" description:"Wallet RPC server certificate used to verify both the GRPC and JSON-RPC servers"`
+	GRPCServerName     string        `long:"grpcservername" description:"Server name to verify the GRPC server certificate against, defaults to the host of --grpcserver"`
+	ClientCert         string        `long:"clientcert" description:"Client certificate for wallets using client certificate authentication, must be used with --clientkey"`
+	ClientKey          string        `long:"clientkey" description:"Private key of the client certificate, must be used with --clientcert"`
+	RPCUser            string        `long:"rpcuser" description:"JSON-RPC username and default dcrwallet GRPC username"`
+	RPCPass            string        `long:"rpcPass" description:"JSON-RPC password and default dcrwallet GRPC password"`
+	RPCTimeout         time.Duration `long:"rpctimeout" description:"Timeout of a single JSON-RPC request"`
+	WalletPassphrase   string        `long:"walletpass" description:"Wallet passphrase, prefer --walletpassfile, the TICKETBUYER_WALLETPASS environment variable or the interactive prompt"`
+	WalletPassFile     string        `long:"walletpassfile" description:"Path to a file only readable by its owner containing the wallet passphrase"`
+	PoolMode           bool          `long:"poolmode" description:"purchase tickets voted by a legacy stakepool, must be used with --purchaseticket"`
+	PoolVotingAddress  string        `long:"poolvotingaddress" description:"P2SH multisig voting address of the stakepool, used with --poolmode"`
+	PoolVotingScript   string        `long:"poolvotingscript" description:"hex encoded multisig voting script of the stakepool, used with --poolmode instead of or to verify --poolvotingaddress"`
+	PoolFeeAddress     string        `long:"poolfeeaddress" description:"address of the stakepool committed to the pool fee, used with --poolmode"`
+	PoolFees           float64       `long:"poolfees" description:"pool fee percentage of the stakepool, used with --poolmode"`
+	VSPURL             string        `long:"vspurl" description:"URL of a vspd compatible voting service provider to purchase tickets voted by, must be used with --purchaseticket and without --grpconly as the voting keys are exported over JSON-RPC"`
+	VSPPubKey          string        `long:"vsppubkey" description:"Base64 encoded public key of the VSP verifying its responses, defaults to the key first reported by the VSP, which is pinned for later runs"`
+	VSPMaxFee          float64       `long:"vspmaxfee" description:"largest VSP fee in DCR paid for a single ticket"`
+	MaxTicketCost      float64       `long:"maxticketcost" description:"largest cost in DCR of a single ticket including its fee, 0 disables the limit"`
+	MaxTicketsPerDay   int           `long:"maxticketsperday" description:"largest number of tickets purchased in the last 24 hours, 0 disables the limit"`
+	MaxWindowTickets   int           `long:"maxticketsperwindow" description:"largest number of tickets purchased in a stake difficulty window, 0 disables the limit"`
+	MaxSpend           float64       `long:"maxspend" description:"largest amount in DCR spent on tickets and sent to others in --spendperiod, 0 disables the limit"`
+	SpendPeriod        time.Duration `long:"spendperiod" description:"rolling period limited by --maxspend"`
+	MaxFeePercent      float64       `long:"maxfeepercent" description:"largest fee of a transaction or ticket as a percentage of the amount it pays, 0 disables the limit"`
+	JournalFile        string        `long:"journalfile" description:"Path to the journal of in-flight ticket purchases, defaults to purchases.json in the network directory of the app data dir"`
+
+	params            *netParams
+	walletPass        []byte
+	ticketFee         dcrutil.Amount
+	txFee             dcrutil.Amount
+	balanceToMaintain dcrutil.Amount
+	maxPrice          dcrutil.Amount
+	coinSelector      CoinSelector
+	fundingTolerance  dcrutil.Amount
+	minConf           int32
+	dataDir           string
+	vspPubKey         ed25519.PublicKey
+	vspMaxFee         dcrutil.Amount
+	vspTicketsFile    string
+	vspPubKeysFile    string
+	poolVotingAddress dcrutil.Address
+	poolFeeAddress    dcrutil.Address
+	votingXPubFile    string
+	maxTicketCost     dcrutil.Amount
+	maxSpend          dcrutil.Amount
+	spendLimitsFile   string
+	ticketFeeLimits   uint16
+
+	// The accounts are resolved against the wallet once connected.
+	sourceAccount     uint32
+	sourceAccountName string
+	changeAccount     uint32
+	votingAccount     uint32
 }
 
 var defaultConfig = config{
-	Network:           defaultNetwork,
-	SourceAccountName: "default",
-	SourceAccount:     defaultSourceAccount,
-	ChangeAccount:     defaultChangeAccount,
-	VotingAccount:     defaultVotingAccount,
-	RPCUser:           defaultRPCUser,
-	RPCPass:           defaultRPCPass,
-	GRPCServer:        defaultGRPCServer,
-	RPCServer:         defaultJSONRPCServer,
+	ConfigFile:       defaultConfigFile,
+	CoinSelect:       coinSelectRandom,
+	RPCCert:          defaultRPCCert,
+	Network:          defaultNetwork,
+	SourceAccount:    defaultSourceAccount,
+	ChangeAccount:    defaultChangeAccount,
+	VotingAccount:    defaultVotingAccount,
+	RPCUser:          defaultRPCUser,
+	RPCPass:          defaultRPCPass,
+	RPCTimeout:       defaultRPCTimeout,
+	TicketFee:        defaultRelayFee,
+	TxFee:            defaultRelayFee,
+	NumTickets:       defaultNumTickets,
+	MaxPerBlock:      defaultMaxPerBlock,
+	Strategy:         strategyPriceCeiling,
+	AverageWindows:   defaultAverageWindows,
+	TicketsPerWindow: defaultTicketsPerWindow,
+	MinConf:          defaultMinConf,
+	WindowMargin:     defaultWindowMargin,
+	VSPMaxFee:        defaultVSPMaxFee,
+	SpendPeriod:      defaultSpendPeriod,
+	MaxVoteFee:       defaultMaxVoteFee,
+	MaxRevokeFee:     defaultMaxRevokeFee,
 }
 
 // loadConfig initializes and parses the config using a config file and command
 // line options.
+//
+// The config file uses the INI format.  Options in the default section (or
+// before any section header) apply to every network, while options in a
+// section named after a network, such as [testnet3], only apply when that
+// network is active and take precedence over the default section.  Command
+// line options take precedence over the config file.
 func loadConfig() (*config, error) {
 	loadConfigError := func(err error) (*config, error) {
 		return nil, err
@@ -65,60 +195,353 @@ func loadConfig() (*config, error) {
 	// // Default config
 	cfg := defaultConfig
 
-	parser := flags.NewParser(&cfg, flags.HelpFlag|flags.PassDoubleDash)
-	_, flagerr := parser.Parse()
+	// Pre-parse the command line options to find the config file and to
+	// handle the help flag.
+	preCfg := defaultConfig
+	preParser := flags.NewParser(&preCfg, flags.HelpFlag|flags.PassDoubleDash)
+	_, flagerr := preParser.Parse()
 
 	if flagerr != nil {
 		e, ok := flagerr.(*flags.Error)
 		if !ok || e.Type != flags.ErrHelp {
-			parser.WriteHelp(os.Stderr)
+			preParser.WriteHelp(os.Stderr)
 		}
 		if ok && e.Type == flags.ErrHelp {
-			parser.WriteHelp(os.Stdout)
+			preParser.WriteHelp(os.Stdout)
 			os.Exit(0)
 		}
 		return loadConfigError(flagerr)
 	}
 
-	actionError := errors.New("Specify either --sendtx or --purchaseticket")
-	if cfg.PurchaseTicket == cfg.SendTx { // both can't be false or true
-		return loadConfigError(actionError)
+	cfg.ConfigFile = preCfg.ConfigFile
+	sections, err := configSections(cfg.ConfigFile)
+	if err != nil {
+		// A missing config file is only an error when its path was
+		// explicitly given.
+		if !os.IsNotExist(err) || cfg.ConfigFile != defaultConfigFile {
+			return loadConfigError(fmt.Errorf("error reading config file: %v", err))
+		}
+	} else {
+		warnConfigFilePermissions(cfg.ConfigFile)
+
+		err = parseConfigSection(&cfg, sections[defaultSectionName])
+		if err != nil {
+			return loadConfigError(fmt.Errorf("error parsing config file %s: %v", cfg.ConfigFile, err))
+		}
+	}
+
+	// Parse the command line options once to learn the active network,
+	// which may also have been set in the default section of the config
+	// file.
+	parser := flags.NewParser(&cfg, flags.HelpFlag|flags.PassDoubleDash)
+	_, err = parser.Parse()
+	if err != nil {
+		return loadConfigError(err)
 	}
 
-	var activeNet *chaincfg.Params
-	if cfg.Network == chaincfg.TestNet3Params().Name {
-		activeNet = chaincfg.TestNet3Params()
-	} else if cfg.Network != chaincfg.MainNetParams().Name {
-		activeNet = chaincfg.MainNetParams()
-	} else {
-		return loadConfigError(fmt.Errorf("network must be testnet3 or mainnet"))
+	for name := range sections {
+		if name == defaultSectionName {
+			continue
+		}
+		if _, err := resolveNetwork(name); err != nil {
+			return loadConfigError(fmt.Errorf("config file %s: invalid section [%s]: %v", cfg.ConfigFile, name, err))
+		}
+	}
+
+	if netSection, ok := sections[cfg.Network]; ok {
+		err = parseConfigSection(&cfg, netSection)
+		if err != nil {
+			return loadConfigError(fmt.Errorf("error parsing config file %s: %v", cfg.ConfigFile, err))
+		}
+
+		// Parse the command line options again so they take precedence
+		// over the network section.
+		_, err = parser.Parse()
+		if err != nil {
+			return loadConfigError(err)
+		}
+	}
+
+	var actions int
+	for _, action := range []bool{cfg.SendTx, cfg.PurchaseTicket, cfg.StakeInfo} {
+		if action {
+			actions++
+		}
+	}
+	if actions != 1 {
+		return loadConfigError(errors.New("Specify one of --sendtx, --purchaseticket or --stakeinfo"))
+	}
+
+	cfg.params, err = resolveNetwork(cfg.Network)
+	if err != nil {
+		return loadConfigError(err)
+	}
+
+	// Files written by the buyer are kept apart for every network.
+	cfg.dataDir = filepath.Join(defaultAppDataDir, cfg.params.Name)
+	if cfg.JournalFile == "" {
+		cfg.JournalFile = filepath.Join(cfg.dataDir, defaultJournalFilename)
 	}
 
-	var err error
-	cfg.GRPCServer, err = NormalizeAddress(cfg.GRPCServer, defaultGPRCPort)
+	if cfg.GRPCServer == "" {
+		cfg.GRPCServer = defaultRPCHost
+	}
+	cfg.GRPCServer, err = NormalizeAddress(cfg.GRPCServer, cfg.params.GRPCServerPort)
 	if err != nil {
 		return loadConfigError(fmt.Errorf("invalid grpc server address: %v", err))
 	}
 
-	cfg.RPCServer, err = NormalizeAddress(cfg.RPCServer, defaultJSONRPCPort)
+	if cfg.RPCServer == "" {
+		cfg.RPCServer = defaultRPCHost
+	}
+	cfg.RPCServer, err = NormalizeAddress(cfg.RPCServer, cfg.params.JSONRPCServerPort)
 	if err != nil {
 		return loadConfigError(fmt.Errorf("invalid json-rpc server address: %v", err))
 	}
 
-	if cfg.SourceAccountName == "" {
-		return loadConfigError(fmt.Errorf("source account name must be set"))
+	if cfg.TicketFee <= 0 || cfg.TxFee <= 0 {
+		return loadConfigError(fmt.Errorf("ticketfee and txfee must be a >0"))
+	}
+	cfg.ticketFee, err = dcrutil.NewAmount(cfg.TicketFee)
+	if err != nil {
+		return loadConfigError(fmt.Errorf("ticketfee error: %v", err))
+	}
+	cfg.txFee, err = dcrutil.NewAmount(cfg.TxFee)
+	if err != nil {
+		return loadConfigError(fmt.Errorf("txfee error: %v", err))
+	}
+
+	cfg.coinSelector, err = newCoinSelector(cfg.CoinSelect)
+	if err != nil {
+		return loadConfigError(err)
+	}
+
+	if cfg.FundingTolerance < 0 || cfg.FundingTolerance > maxFundingTolerance {
+		return loadConfigError(fmt.Errorf("fundingtolerance must be between 0 and %v",
+			maxFundingTolerance))
+	}
+	cfg.fundingTolerance, err = dcrutil.NewAmount(cfg.FundingTolerance)
+	if err != nil {
+		return loadConfigError(fmt.Errorf("fundingtolerance error: %v", err))
+	}
+
+	if cfg.RPCTimeout <= 0 {
+		return loadConfigError(fmt.Errorf("rpctimeout must be a >0"))
+	}
+
+	if (cfg.ClientCert == "") != (cfg.ClientKey == "") {
+		return loadConfigError(fmt.Errorf("--clientcert and --clientkey must be used together"))
+	}
+
+	// Dry runs and stake info never sign, so the passphrase is not needed.
+	if !cfg.DryRun && !cfg.StakeInfo {
+		fromFile := cfg.WalletPassphrase == "" && cfg.WalletPassFile != ""
+		cfg.walletPass, err = loadWalletPassphrase(&cfg)
+		if err != nil {
+			return loadConfigError(err)
+		}
+
+		// The daemon reads the passphrase file again for every
+		// signing, it is only read here to report problems early.
+		if cfg.Daemon && fromFile {
+			zeroBytes(cfg.walletPass)
+			cfg.walletPass = nil
+		}
+	}
+	// Only the byte slice is kept so it can be cleared once the passphrase
+	// is no longer needed, the string is dropped even when it is not
+	// loaded.
+	cfg.WalletPassphrase = ""
+
+	if cfg.MinConf < 0 {
+		return loadConfigError(fmt.Errorf("minconf must be a >=0"))
+	}
+
+	cfg.minConf = minConfirmations(&cfg)
+
+	if cfg.FundingConfs < 0 {
+		return loadConfigError(fmt.Errorf("fundingconfs must be a >=0"))
+	}
+
+	if cfg.MaxTicketCost < 0 {
+		return loadConfigError(fmt.Errorf("maxticketcost must be a >=0"))
+	}
+	cfg.maxTicketCost, err = dcrutil.NewAmount(cfg.MaxTicketCost)
+	if err != nil {
+		return loadConfigError(fmt.Errorf("maxticketcost error: %v", err))
 	}
 
-	if cfg.WalletPassphrase == "" {
-		return loadConfigError(fmt.Errorf("wallet passphrase must be set"))
+	if cfg.MaxTicketsPerDay < 0 || cfg.MaxWindowTickets < 0 {
+		return loadConfigError(fmt.Errorf("maxticketsperday and maxticketsperwindow must be a >=0"))
 	}
 
+	if cfg.MaxSpend < 0 {
+		return loadConfigError(fmt.Errorf("maxspend must be a >=0"))
+	}
+	cfg.maxSpend, err = dcrutil.NewAmount(cfg.MaxSpend)
+	if err != nil {
+		return loadConfigError(fmt.Errorf("maxspend error: %v", err))
+	}
+
+	if cfg.SpendPeriod <= 0 {
+		return loadConfigError(fmt.Errorf("spendperiod must be a >0"))
+	}
+
+	if cfg.MaxFeePercent < 0 || cfg.MaxFeePercent > 100 {
+		return loadConfigError(fmt.Errorf("maxfeepercent must be between 0 and 100"))
+	}
+
+	// Spendings are recorded on disk so the limits hold across restarts.
+	cfg.spendLimitsFile = filepath.Join(cfg.dataDir, defaultSpendLimitsFilename)
+
+	voteExp, voteOK, err := parseFeeLimit(cfg.MaxVoteFee)
+	if err != nil {
+		return loadConfigError(fmt.Errorf("maxvotefee error: %v", err))
+	}
+	revokeExp, revokeOK, err := parseFeeLimit(cfg.MaxRevokeFee)
+	if err != nil {
+		return loadConfigError(fmt.Errorf("maxrevokefee error: %v", err))
+	}
+	cfg.ticketFeeLimits = encodeFeeLimits(voteExp, voteOK, revokeExp, revokeOK)
+
+	if cfg.TicketExpiry < 0 || cfg.TxExpiry < 0 {
+		return loadConfigError(fmt.Errorf("ticketexpiry and txexpiry must be a >=0"))
+	}
+
+	if cfg.WindowMargin < 0 {
+		return loadConfigError(fmt.Errorf("windowmargin must be a >=0"))
+	}
+
+	if cfg.NumTickets < 1 {
+		return loadConfigError(fmt.Errorf("numtickets must be a >0"))
+	}
+
+	if cfg.Daemon {
+		if !cfg.PurchaseTicket {
+			return loadConfigError(fmt.Errorf("--daemon must be used with --purchaseticket"))
+		}
+
+		if cfg.MaxPerBlock < 1 {
+			return loadConfigError(fmt.Errorf("maxperblock must be a >0"))
+		}
+
+		if cfg.BalanceToMaintain < 0 {
+			return loadConfigError(fmt.Errorf("balancetomaintain must be a >=0"))
+		}
+		cfg.balanceToMaintain, err = dcrutil.NewAmount(cfg.BalanceToMaintain)
+		if err != nil {
+			return loadConfigError(fmt.Errorf("balancetomaintain error: %v", err))
+		}
+
+		if cfg.MaxPrice < 0 {
+			return loadConfigError(fmt.Errorf("maxprice must be a >=0"))
+		}
+		cfg.maxPrice, err = dcrutil.NewAmount(cfg.MaxPrice)
+		if err != nil {
+			return loadConfigError(fmt.Errorf("maxprice error: %v", err))
+		}
+		if cfg.maxPrice > 0 && cfg.Strategy != strategyPriceCeiling {
+			return loadConfigError(fmt.Errorf("--maxprice must be used with --strategy=%s",
+				strategyPriceCeiling))
+		}
+
+		switch cfg.Strategy {
+		case strategyMovingAverage:
+			if cfg.AverageWindows < 1 {
+				return loadConfigError(fmt.Errorf("averagewindows must be a >0"))
+			}
+		case strategyPerWindow:
+			if cfg.TicketsPerWindow < 1 {
+				return loadConfigError(fmt.Errorf("ticketsperwindow must be a >0"))
+			}
+		case strategyPriceCeiling, strategyMaintainBalance:
+		default:
+			return loadConfigError(fmt.Errorf("unknown buying strategy %q", cfg.Strategy))
+		}
+	}
+
+	if cfg.VotingXPub != "" {
+		if !cfg.PurchaseTicket {
+			return loadConfigError(fmt.Errorf("--votingxpub must be used with --purchaseticket"))
+		}
+
+		// Stakepools vote with their own address and VSPs need the
+		// private key of the voting address.
+		if cfg.PoolMode || cfg.VSPURL != "" {
+			return loadConfigError(fmt.Errorf("--votingxpub can not be used with --poolmode or --vspurl"))
+		}
+
+		cfg.votingXPubFile = filepath.Join(cfg.dataDir, defaultVotingXPubFilename)
+	}
+
+	if cfg.PoolMode {
+		if !cfg.PurchaseTicket {
+			return loadConfigError(fmt.Errorf("--poolmode must be used with --purchaseticket"))
+		}
+
+		if cfg.VSPURL != "" {
+			return loadConfigError(fmt.Errorf("--poolmode and --vspurl can not be used together"))
+		}
+
+		cfg.poolVotingAddress, err = resolvePoolVotingAddress(cfg.PoolVotingScript,
+			cfg.PoolVotingAddress, cfg.params)
+		if err != nil {
+			return loadConfigError(err)
+		}
+
+		if cfg.PoolFeeAddress == "" {
+			return loadConfigError(fmt.Errorf("--poolmode requires --poolfeeaddress"))
+		}
+		cfg.poolFeeAddress, err = dcrutil.DecodeAddress(cfg.PoolFeeAddress, cfg.params)
+		if err != nil {
+			return loadConfigError(fmt.Errorf("decode poolfeeaddress error: %v", err))
+		}
+
+		if !txrules.IsValidPoolFeeRate(cfg.PoolFees) {
+			return loadConfigError(fmt.Errorf("poolfees must be a valid pool fee percentage"))
+		}
+	}
+
+	if cfg.VSPURL != "" {
+		if !cfg.PurchaseTicket {
+			return loadConfigError(fmt.Errorf("--vspurl must be used with --purchaseticket"))
+		}
+
+		// The voting key given to the VSP can only be exported over
+		// JSON-RPC.
+		if cfg.GRPCOnly {
+			return loadConfigError(fmt.Errorf("--vspurl can not be used with --grpconly"))
+		}
+
+		if cfg.VSPPubKey != "" {
+			pubKey, err := base64.StdEncoding.DecodeString(cfg.VSPPubKey)
+			if err != nil || len(pubKey) != ed25519.PublicKeySize {
+				return loadConfigError(fmt.Errorf("vsppubkey must be a base64 encoded ed25519 public key"))
+			}
+			cfg.vspPubKey = pubKey
+		}
+
+		if cfg.VSPMaxFee <= 0 {
+			return loadConfigError(fmt.Errorf("vspmaxfee must be a >0"))
+		}
+		cfg.vspMaxFee, err = dcrutil.NewAmount(cfg.VSPMaxFee)
+		if err != nil {
+			return loadConfigError(fmt.Errorf("vspmaxfee error: %v", err))
+		}
+
+		cfg.vspPubKeysFile = filepath.Join(cfg.dataDir, defaultVSPPubKeysFilename)
+	}
+
+	// The inputs of VSP fee transactions of earlier purchases stay reserved
+	// when sending without a VSP.
+	cfg.vspTicketsFile = filepath.Join(cfg.dataDir, defaultVSPTicketsFilename)
+
 	if cfg.SendTx {
 		if cfg.DestinationAddress == "" {
 			return loadConfigError(fmt.Errorf("destination address must be set when using --sendtx"))
 		}
 
-		_, err = dcrutil.DecodeAddress(cfg.DestinationAddress, activeNet)
+		_, err = dcrutil.DecodeAddress(cfg.DestinationAddress, cfg.params)
 		if err != nil {
 			return loadConfigError(fmt.Errorf("decode destaddr error: %v", err))
 		}
@@ -135,3 +558,13 @@ func loadConfig() (*config, error) {
 
 	return &cfg, nil
 }
+
+// minConfirmations returns the number of confirmations required of the source
+// account outputs spent by regular and funding transactions.
+// --spendunconfirmed overrides --minconf.
+func minConfirmations(cfg *config) int32 {
+	if cfg.SpendUnconfirmed {
+		return 0
+	}
+	return cfg.MinConf
+}
@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestMinConfirmations(t *testing.T) {
+	tests := []struct {
+		name             string
+		minConf          int32
+		spendUnconfirmed bool
+		want             int32
+	}{{
+		name:    "default",
+		minConf: defaultMinConf,
+		want:    1,
+	}, {
+		name:             "spend unconfirmed",
+		minConf:          defaultMinConf,
+		spendUnconfirmed: true,
+		want:             0,
+	}, {
+		name:    "minconf",
+		minConf: 6,
+		want:    6,
+	}, {
+		name:             "spend unconfirmed overrides minconf",
+		minConf:          6,
+		spendUnconfirmed: true,
+		want:             0,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := defaultConfig
+			cfg.MinConf = test.minConf
+			cfg.SpendUnconfirmed = test.spendUnconfirmed
+
+			if got := minConfirmations(&cfg); got != test.want {
+				t.Fatalf("minimum confirmations %d, want %d", got, test.want)
+			}
+		})
+	}
+}
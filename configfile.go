@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	flags "github.com/jessevdk/go-flags"
+)
+
+// defaultSectionName is the INI section holding options that apply to every
+// network.  Options written before any section header are treated the same.
+const defaultSectionName = "Application Options"
+
+// configSections splits the INI config file at path into the bodies of its
+// sections, keyed by section name.  The default section is keyed by
+// defaultSectionName.
+func configSections(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sections := make(map[string]*strings.Builder)
+	section := defaultSectionName
+	sections[section] = new(strings.Builder)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if sections[section] == nil {
+				sections[section] = new(strings.Builder)
+			}
+			continue
+		}
+		sections[section].WriteString(line)
+		sections[section].WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	bodies := make(map[string]string, len(sections))
+	for name, body := range sections {
+		bodies[name] = body.String()
+	}
+	return bodies, nil
+}
+
+// parseConfigSection applies the options of a single config file section to
+// cfg.
+func parseConfigSection(cfg *config, body string) error {
+	parser := flags.NewParser(cfg, flags.None)
+	return flags.NewIniParser(parser).Parse(strings.NewReader(body))
+}
+
+// warnConfigFilePermissions prints a warning when the config file at path can
+// be read by users other than its owner, since it may hold credentials.
+func warnConfigFilePermissions(path string) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+
+	if fi.Mode().Perm()&0077 != 0 {
+		fmt.Fprintf(os.Stderr, "Warning: config file %s has permissions %v, "+
+			"restrict them with chmod 600 to protect credentials\n", path,
+			fi.Mode().Perm())
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+)
+
+// waitForConfirmations reads notifications until the transaction with hash
+// txHash has been mined and has at least confs confirmations.  A block that
+// mined the transaction and is detached by a reorg resets the count.  The
+// stream must have been subscribed before the transaction was published so
+// the block mining it is not missed.
+func waitForConfirmations(notifications notificationStream, txHash *chainhash.Hash, confs int32) error {
+	var minedBlock []byte
+	var minedHeight int32
+
+	for {
+		notification, err := notifications.Recv()
+		if err != nil {
+			return err
+		}
+
+		for _, detached := range notification.DetachedBlocks {
+			if minedBlock != nil && bytes.Equal(detached, minedBlock) {
+				fmt.Printf("Block mining %s was detached\n", txHash)
+				minedBlock = nil
+			}
+		}
+
+		for _, block := range notification.AttachedBlocks {
+			if minedBlock == nil {
+				for _, tx := range block.Transactions {
+					if bytes.Equal(tx.Hash, txHash[:]) {
+						minedBlock = block.Hash
+						minedHeight = block.Height
+						break
+					}
+				}
+			}
+			if minedBlock == nil {
+				continue
+			}
+
+			confirmations := block.Height - minedHeight + 1
+			fmt.Printf("Transaction %s has %d of %d confirmations\n", txHash,
+				confirmations, confs)
+			if confirmations >= confs {
+				return nil
+			}
+		}
+	}
+}
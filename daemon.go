@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/decred/dcrd/dcrutil/v2"
+)
+
+const (
+	// minReconnectDelay and maxReconnectDelay bound the exponential backoff
+	// used when the notification stream has to be re-established.
+	minReconnectDelay = time.Second
+	maxReconnectDelay = time.Minute
+)
+
+// run buys tickets as new blocks are attached to the main chain until ctx is
+// canceled.  A failed notification stream is re-established with an
+// exponential backoff.
+func (tb *TicketBuyer) run(ctx context.Context) error {
+	delay := minReconnectDelay
+	resetDelay := func() { delay = minReconnectDelay }
+
+	for {
+		err := tb.listenForBlockNotifications(ctx, resetDelay)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		fmt.Printf("Notification stream error: %v, reconnecting in %s\n", err, delay)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// listenForBlockNotifications subscribes to the wallet's transaction
+// notifications and considers a purchase for every notification that
+// attaches blocks.  It only returns once the stream fails or ctx is canceled.
+// received is called for every notification read from the stream.
+func (tb *TicketBuyer) listenForBlockNotifications(ctx context.Context, received func()) error {
+	notificationClient, err := tb.wallet.TransactionNotifications(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Listening for block notifications")
+	for {
+		notificationResponse, err := notificationClient.Recv()
+		if err != nil {
+			return err
+		}
+		received()
+
+		numAttachedBlocks := len(notificationResponse.AttachedBlocks)
+		if numAttachedBlocks == 0 {
+			// Unmined transaction notification.
+			continue
+		}
+
+		tipHeight := notificationResponse.AttachedBlocks[numAttachedBlocks-1].Height
+		fmt.Printf("%d block(s) attached, height %d\n", numAttachedBlocks, tipHeight)
+
+		// Purchase failures are not fatal to the daemon, the next block
+		// gets another attempt.
+		if err := tb.handleAttachedBlocks(); err != nil {
+			fmt.Printf("Ticket purchase failed: %v\n", err)
+		}
+	}
+}
+
+// handleAttachedBlocks refreshes the relay fees, retries interrupted
+// purchases and VSP fee payments and buys as many tickets as the buying
+// strategy decides.
+func (tb *TicketBuyer) handleAttachedBlocks() error {
+	err := tb.updateFees()
+	if err != nil {
+		return err
+	}
+
+	// Interrupted purchases are retried with every block and do not stop
+	// new purchases.
+	if err := tb.resumePurchases(); err != nil {
+		fmt.Println(err)
+	}
+	if err := tb.processVSPTickets(); err != nil {
+		fmt.Println(err)
+	}
+
+	state, numTickets, err := tb.ticketsToBuy()
+	if err != nil {
+		return err
+	}
+
+	if numTickets == 0 {
+		return nil
+	}
+
+	// Tickets published before a failure count against the strategy as
+	// well.
+	purchased, err := tb.purchaseTickets(numTickets)
+	if purchased == 0 || tb.cfg.DryRun {
+		return err
+	}
+	strategyErr := tb.strategy.Purchased(state, purchased)
+	if err != nil {
+		if strategyErr != nil {
+			fmt.Printf("Recording the purchased tickets failed: %v\n", strategyErr)
+		}
+		return err
+	}
+	return strategyErr
+}
+
+// ticketsToBuy decides how many tickets to buy for the next block using the
+// buying strategy.  No tickets are bought when the stake difficulty window is
+// about to end, the spendable balance of the source account is never taken
+// below the balance to maintain and the result is capped at the configured
+// maximum per block.
+func (tb *TicketBuyer) ticketsToBuy() (*blockState, int, error) {
+	ticketPrice, err := tb.getTicketPrice()
+	if err != nil {
+		return nil, 0, err
+	}
+	fmt.Printf("Ticket Price: %s\n", ticketPrice)
+
+	window, err := tb.currentStakeWindow()
+	if err != nil {
+		return nil, 0, err
+	}
+	fmt.Printf("Next block is %s\n", window)
+	if tb.windowClosing(window) {
+		fmt.Printf("Deferring purchases until the next stake difficulty window\n")
+		return nil, 0, nil
+	}
+
+	spendable, err := tb.spendableBalance()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// The funding transaction fee is not included, it is negligible
+	// compared to the price of a ticket.
+	ticketFee, _, err := tb.ticketCosts(ticketPrice)
+	if err != nil {
+		return nil, 0, err
+	}
+	ticketCost := ticketPrice + ticketFee
+
+	state := &blockState{
+		window:      window,
+		ticketPrice: ticketPrice,
+	}
+	if available := spendable - tb.cfg.balanceToMaintain; available > 0 {
+		state.affordable = int(available / ticketCost)
+	}
+	if state.affordable == 0 {
+		fmt.Printf("Insufficient balance above %s to buy a ticket\n", tb.cfg.balanceToMaintain)
+	}
+
+	numTickets, err := tb.strategy.TicketsToBuy(state)
+	if err != nil {
+		return nil, 0, err
+	}
+	if numTickets <= 0 {
+		return state, 0, nil
+	}
+
+	if numTickets > state.affordable {
+		if state.affordable > 0 {
+			fmt.Printf("Insufficient balance above %s to buy %d ticket(s)\n",
+				tb.cfg.balanceToMaintain, numTickets)
+		}
+		numTickets = state.affordable
+	}
+	if numTickets > tb.cfg.MaxPerBlock {
+		numTickets = tb.cfg.MaxPerBlock
+	}
+
+	return state, numTickets, nil
+}
+
+// spendableBalance returns the spendable balance of the source account,
+// excluding the funding outputs of pending purchases.
+func (tb *TicketBuyer) spendableBalance() (dcrutil.Amount, error) {
+	ctx := context.Background()
+
+	spendableBal, err := tb.wallet.SpendableBalance(ctx, tb.cfg.sourceAccount, tb.cfg.minConf)
+	if err != nil {
+		return 0, err
+	}
+
+	// Only the funding outputs with enough confirmations are part of the
+	// spendable balance.
+	reserved := tb.journal.reserved()
+	if len(reserved) != 0 {
+		utxos, err := tb.wallet.UnspentOutputs(ctx, tb.cfg.sourceAccount, tb.cfg.minConf)
+		if err != nil {
+			return 0, err
+		}
+		for _, utxo := range utxos {
+			if reserved[utxo.OutPoint] {
+				spendableBal -= utxo.Amount
+			}
+		}
+	}
+	if spendableBal < 0 {
+		spendableBal = 0
+	}
+	fmt.Printf("Mixed account spendable balance: %s\n", spendableBal)
+	return spendableBal, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/decred/dcrd/dcrutil/v2"
+)
+
+func TestHandleAttachedBlocksFundingConfs(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	cfg := newTestConfig(dir)
+	cfg.Daemon = true
+	cfg.FundingConfs = 2
+	cfg.MaxWindowTickets = 1
+	w := newTestWallet()
+	tb := newTestTicketBuyer(t, cfg, w)
+	tb.strategy = maintainBalanceStrategy{}
+	err := w.addUnspentOutput(0, 10*dcrutil.AtomsPerCoin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first block funds the ticket allowed in the window.
+	err = tb.handleAttachedBlocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if published := w.publishedTransactions(); len(published) != 1 {
+		t.Fatalf("published %d transactions, want the funding transaction",
+			len(published))
+	}
+
+	// The ticket waiting for its funding confirmations counts against the
+	// window limit.
+	w.connectBlock(1)
+	err = tb.handleAttachedBlocks()
+	checkLimitError(t, err, "maxticketsperwindow")
+	if published := w.publishedTransactions(); len(published) != 1 {
+		t.Fatalf("published %d transactions with an unconfirmed funding "+
+			"transaction, want 1", len(published))
+	}
+
+	// Once the funding transaction is confirmed only its ticket is
+	// published.
+	w.connectBlock(2)
+	err = tb.handleAttachedBlocks()
+	checkLimitError(t, err, "maxticketsperwindow")
+	published := w.publishedTransactions()
+	if len(published) != 2 {
+		t.Fatalf("published %d transactions, want the funding transaction "+
+			"and its ticket", len(published))
+	}
+	prevOut := published[1].TxIn[0].PreviousOutPoint
+	if prevOut.Hash != published[0].TxHash() {
+		t.Fatalf("ticket spends %s, want an output of the funding transaction",
+			prevOut)
+	}
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/chaincfg/v2"
+	"github.com/decred/dcrd/dcrec"
+	"github.com/decred/dcrd/dcrutil/v2"
+	"github.com/decred/dcrd/txscript/v2"
+	"github.com/decred/dcrd/wire"
+	"github.com/decred/dcrwallet/errors/v2"
+	pb "github.com/decred/dcrwallet/rpc/walletrpc"
+)
+
+// fakeWallet is an in-memory WalletBackend.  It derives deterministic
+// addresses, tracks the unspent outputs of every account and applies
+// published transactions to them without signing anything, so purchases can
+// be exercised without a running wallet.  Published transactions are mined by
+// the next attached block.
+type fakeWallet struct {
+	mtx sync.Mutex
+
+	netParams   *chaincfg.Params
+	passphrase  []byte
+	ticketPrice dcrutil.Amount
+	ticketFee   dcrutil.Amount
+	txFee       dcrutil.Amount
+
+	accounts      []walletAccount
+	height        int32
+	nextAddrIndex uint32
+	addrAccounts  map[string]uint32
+	utxos         map[uint32][]*unspentOutput
+	published     []*wire.MsgTx
+
+	// minedHeights holds the height of the block mining every unspent
+	// output, unmined outputs are missing.
+	minedHeights map[wire.OutPoint]int32
+
+	// signErr and publishErr, when set, are returned by every call to
+	// SignTransaction and PublishTransaction.
+	signErr    error
+	publishErr error
+
+	notifications chan *pb.TransactionNotificationsResponse
+}
+
+func newFakeWallet(netParams *chaincfg.Params, passphrase []byte, ticketPrice, relayFee dcrutil.Amount) *fakeWallet {
+	return &fakeWallet{
+		netParams:     netParams,
+		passphrase:    passphrase,
+		ticketPrice:   ticketPrice,
+		ticketFee:     relayFee,
+		txFee:         relayFee,
+		accounts:      []walletAccount{{Number: 0, Name: "default"}},
+		addrAccounts:  make(map[string]uint32),
+		utxos:         make(map[uint32][]*unspentOutput),
+		minedHeights:  make(map[wire.OutPoint]int32),
+		notifications: make(chan *pb.TransactionNotificationsResponse),
+	}
+}
+
+// addAccount creates the account number with the given name.
+func (w *fakeWallet) addAccount(number uint32, name string) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	w.accounts = append(w.accounts, walletAccount{Number: number, Name: name})
+}
+
+// addUnspentOutput credits account with an output of amount paying to a new
+// address of the account, mined by the current block.
+func (w *fakeWallet) addUnspentOutput(account uint32, amount dcrutil.Amount) error {
+	addr, err := w.NextAddress(context.Background(), account, false)
+	if err != nil {
+		return err
+	}
+	pkScript, _, err := addressScript(addr)
+	if err != nil {
+		return err
+	}
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	// Every output gets a unique funding transaction hash.
+	var txHash chainhash.Hash
+	binary.LittleEndian.PutUint32(txHash[:], uint32(len(w.utxos[account])))
+	binary.LittleEndian.PutUint32(txHash[4:], account)
+	txHash[8] = 0xff
+
+	outPoint := wire.NewOutPoint(&txHash, 0, wire.TxTreeRegular)
+	w.utxos[account] = append(w.utxos[account], &unspentOutput{
+		OutPoint: *outPoint,
+		Amount:   amount,
+		PkScript: pkScript,
+	})
+	w.minedHeights[*outPoint] = w.height
+	return nil
+}
+
+// publishedTransactions returns the transactions published so far.
+func (w *fakeWallet) publishedTransactions() []*wire.MsgTx {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	return append([]*wire.MsgTx(nil), w.published...)
+}
+
+// connectBlock connects a block at height mining every unmined output.
+func (w *fakeWallet) connectBlock(height int32) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	w.height = height
+	for _, utxos := range w.utxos {
+		for _, utxo := range utxos {
+			if _, ok := w.minedHeights[utxo.OutPoint]; !ok {
+				w.minedHeights[utxo.OutPoint] = height
+			}
+		}
+	}
+}
+
+// attachBlock connects a block at height and sends a notification attaching
+// it to every notification subscriber.  It blocks until the notification is
+// received.
+func (w *fakeWallet) attachBlock(ctx context.Context, height int32) error {
+	w.connectBlock(height)
+
+	notification := &pb.TransactionNotificationsResponse{
+		AttachedBlocks: []*pb.BlockDetails{{Height: height}},
+	}
+
+	select {
+	case w.notifications <- notification:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *fakeWallet) UnspentOutputs(ctx context.Context, account uint32, minConf int32) ([]*unspentOutput, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	var utxos []*unspentOutput
+	for _, utxo := range w.utxos[account] {
+		var confs int32
+		if height, ok := w.minedHeights[utxo.OutPoint]; ok {
+			confs = w.height - height + 1
+		}
+		if confs >= minConf {
+			utxos = append(utxos, utxo)
+		}
+	}
+	return utxos, nil
+}
+
+func (w *fakeWallet) TicketRelayFee(ctx context.Context) (dcrutil.Amount, error) {
+	return w.ticketFee, nil
+}
+
+func (w *fakeWallet) TxRelayFee(ctx context.Context) (dcrutil.Amount, error) {
+	return w.txFee, nil
+}
+
+func (w *fakeWallet) NextAddress(ctx context.Context, account uint32, internal bool) (dcrutil.Address, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	var seed [9]byte
+	binary.LittleEndian.PutUint32(seed[:], w.nextAddrIndex)
+	binary.LittleEndian.PutUint32(seed[4:], account)
+	if internal {
+		seed[8] = 1
+	}
+	w.nextAddrIndex++
+
+	addr, err := dcrutil.NewAddressPubKeyHash(dcrutil.Hash160(seed[:]),
+		w.netParams, dcrec.STEcdsaSecp256k1)
+	if err != nil {
+		return nil, err
+	}
+
+	w.addrAccounts[addr.Address()] = account
+	return addr, nil
+}
+
+func (w *fakeWallet) Accounts(ctx context.Context) ([]walletAccount, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	return append([]walletAccount(nil), w.accounts...), nil
+}
+
+// BestBlock returns the height of the last attached block.  Blocks of the fake
+// wallet have no hash.
+func (w *fakeWallet) BestBlock(ctx context.Context) (*chainhash.Hash, int32, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	return new(chainhash.Hash), w.height, nil
+}
+
+func (w *fakeWallet) TicketPrice(ctx context.Context) (dcrutil.Amount, error) {
+	return w.ticketPrice, nil
+}
+
+func (w *fakeWallet) SpendableBalance(ctx context.Context, account uint32, minConf int32) (dcrutil.Amount, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	var balance dcrutil.Amount
+	for _, utxo := range w.utxos[account] {
+		balance += utxo.Amount
+	}
+	return balance, nil
+}
+
+func (w *fakeWallet) SignTransaction(ctx context.Context, passphrase []byte, tx []byte) ([]byte, error) {
+	if w.signErr != nil {
+		return nil, w.signErr
+	}
+	if !bytes.Equal(passphrase, w.passphrase) {
+		return nil, errors.E(errors.Passphrase, "invalid passphrase")
+	}
+
+	// Signature scripts are not committed to by the transaction hash, so
+	// the unsigned transaction stands in for the signed one.
+	return tx, nil
+}
+
+// PublishTransaction spends the unspent outputs referenced by the inputs of
+// tx and credits its regular outputs paying to addresses of the wallet.
+// Transactions paying more than their inputs are rejected.
+func (w *fakeWallet) PublishTransaction(ctx context.Context, tx []byte) (*chainhash.Hash, error) {
+	if w.publishErr != nil {
+		return nil, w.publishErr
+	}
+
+	var msgTx wire.MsgTx
+	if err := msgTx.FromBytes(tx); err != nil {
+		return nil, err
+	}
+
+	var in, out int64
+	for _, txIn := range msgTx.TxIn {
+		in += txIn.ValueIn
+	}
+	for _, txOut := range msgTx.TxOut {
+		out += txOut.Value
+	}
+	if out > in {
+		return nil, errors.E(errors.InsufficientBalance, "outputs exceed inputs")
+	}
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	for _, txIn := range msgTx.TxIn {
+		if !w.spendOutput(txIn.PreviousOutPoint) {
+			return nil, errors.E(errors.DoubleSpend, "input "+
+				txIn.PreviousOutPoint.String()+" is not an unspent output")
+		}
+	}
+
+	txHash := msgTx.TxHash()
+	for i, txOut := range msgTx.TxOut {
+		class, addrs, _, err := txscript.ExtractPkScriptAddrs(txOut.Version,
+			txOut.PkScript, w.netParams)
+		if err != nil || class != txscript.PubKeyHashTy || len(addrs) != 1 {
+			continue
+		}
+
+		account, ok := w.addrAccounts[addrs[0].Address()]
+		if !ok {
+			continue
+		}
+
+		w.utxos[account] = append(w.utxos[account], &unspentOutput{
+			OutPoint: *wire.NewOutPoint(&txHash, uint32(i), wire.TxTreeRegular),
+			Amount:   dcrutil.Amount(txOut.Value),
+			PkScript: txOut.PkScript,
+		})
+	}
+
+	w.published = append(w.published, &msgTx)
+	return &txHash, nil
+}
+
+// spendOutput removes the unspent output at outPoint and returns whether it
+// existed.  The wallet mutex must be held.
+func (w *fakeWallet) spendOutput(outPoint wire.OutPoint) bool {
+	for account, utxos := range w.utxos {
+		for i, utxo := range utxos {
+			if utxo.OutPoint != outPoint {
+				continue
+			}
+
+			w.utxos[account] = append(utxos[:i:i], utxos[i+1:]...)
+			delete(w.minedHeights, outPoint)
+			return true
+		}
+	}
+	return false
+}
+
+func (w *fakeWallet) DumpPrivKey(ctx context.Context, address dcrutil.Address, passphrase []byte) (string, error) {
+	if !bytes.Equal(passphrase, w.passphrase) {
+		return "", errors.E(errors.Passphrase, "invalid passphrase")
+	}
+
+	// The fake wallet has no private keys, the address stands in for its
+	// key.
+	return "fakewif-" + address.Address(), nil
+}
+
+func (w *fakeWallet) SignMessage(ctx context.Context, address dcrutil.Address, message string, passphrase []byte) ([]byte, error) {
+	if !bytes.Equal(passphrase, w.passphrase) {
+		return nil, errors.E(errors.Passphrase, "invalid passphrase")
+	}
+
+	// The signature is a hash committing to the address and message.
+	return chainhash.HashB([]byte(address.Address() + message)), nil
+}
+
+func (w *fakeWallet) Transaction(ctx context.Context, txHash *chainhash.Hash) ([]byte, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	for _, tx := range w.published {
+		if tx.TxHash() == *txHash {
+			return tx.Bytes()
+		}
+	}
+	return nil, errors.E(errors.NotExist, "transaction "+txHash.String()+" not found")
+}
+
+func (w *fakeWallet) TransactionNotifications(ctx context.Context) (notificationStream, error) {
+	return &fakeNotificationStream{
+		ctx:           ctx,
+		notifications: w.notifications,
+	}, nil
+}
+
+// fakeNotificationStream delivers the notifications sent by
+// fakeWallet.attachBlock.
+type fakeNotificationStream struct {
+	ctx           context.Context
+	notifications <-chan *pb.TransactionNotificationsResponse
+}
+
+func (s *fakeNotificationStream) Recv() (*pb.TransactionNotificationsResponse, error) {
+	select {
+	case notification := <-s.notifications:
+		return notification, nil
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	}
+}
+
+// EstimateStakeDiff estimates that the ticket price does not change.
+func (w *fakeWallet) EstimateStakeDiff(ctx context.Context) (*stakeDiffEstimate, error) {
+	return &stakeDiffEstimate{
+		Min:      w.ticketPrice,
+		Expected: w.ticketPrice,
+		Max:      w.ticketPrice,
+	}, nil
+}
+
+const testPassphrase = "passphrase"
+
+// newTestConfig returns the configuration of tests purchasing tickets on
+// simnet, keeping every file in dir.
+func newTestConfig(dir string) *config {
+	return &config{
+		PurchaseTicket:  true,
+		NumTickets:      1,
+		MaxPerBlock:     1,
+		SpendPeriod:     defaultSpendPeriod,
+		JournalFile:     filepath.Join(dir, defaultJournalFilename),
+		params:          &simNetParams,
+		walletPass:      []byte(testPassphrase),
+		coinSelector:    largestFirstSelector{},
+		dataDir:         dir,
+		spendLimitsFile: filepath.Join(dir, defaultSpendLimitsFilename),
+		ticketFeeLimits: encodeFeeLimits(0, false, 24, true),
+		sourceAccount:   0,
+		changeAccount:   1,
+		votingAccount:   2,
+	}
+}
+
+// newTestWallet returns a fake wallet with the source, change and voting
+// accounts of newTestConfig and a ticket price of 1 DCR.
+func newTestWallet() *fakeWallet {
+	w := newFakeWallet(simNetParams.Params, []byte(testPassphrase),
+		dcrutil.AtomsPerCoin, 1e4)
+	w.addAccount(1, "change")
+	w.addAccount(2, "voting")
+	return w
+}
+
+// newTestTicketBuyer returns a ticket buyer of w with the relay fees already
+// updated.
+func newTestTicketBuyer(t *testing.T, cfg *config, w *fakeWallet) *TicketBuyer {
+	t.Helper()
+
+	journal, err := openPurchaseJournal(cfg.JournalFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits, err := openSpendLimits(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tb := NewTicketBuyer(cfg, w, journal, nil, nil, nil, limits, cfg.params.Params)
+	err = tb.updateFees()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tb
+}
+
+// tempDir creates a temporary directory removed by the returned func.
+func tempDir(t *testing.T) (string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "ticketbuyer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"github.com/decred/dcrd/blockchain/stake/v2"
+	"github.com/decred/dcrd/dcrutil/v2"
+)
+
+const (
+	// defaultMaxVoteFee allows no fee to be paid by the vote of a ticket.
+	defaultMaxVoteFee = "0"
+
+	// defaultMaxRevokeFee allows up to 2^24 atoms, about 0.17 DCR, to be
+	// paid by the revocation of a ticket.
+	defaultMaxRevokeFee = "2^24"
+
+	// maxFeeLimitExponent is the largest exponent of a fee limit accepted.
+	// 2^62 atoms is far above the supply of DCR and, unlike 2^63, still
+	// fits an amount.
+	maxFeeLimitExponent = 62
+)
+
+// parseFeeLimit parses the maximum fee a vote or revocation may pay out of a
+// ticket commitment, either an amount in DCR or an exponent written as 2^N.
+// The commitment can only limit the fee to a power of two atoms, so an amount
+// is rounded down to one.  A zero fee disables paying a fee.  The exponent of
+// the limit is returned with ok set when a fee may be paid.
+func parseFeeLimit(value string) (exponent uint16, ok bool, err error) {
+	if strings.HasPrefix(value, "2^") {
+		n, err := strconv.ParseUint(value[2:], 10, 16)
+		if err != nil || n > maxFeeLimitExponent {
+			return 0, false, fmt.Errorf("exponent of %q must be between 0 and %d",
+				value, maxFeeLimitExponent)
+		}
+		return uint16(n), true, nil
+	}
+
+	dcr, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("%q is neither an amount in DCR nor 2^N", value)
+	}
+	if dcr < 0 {
+		return 0, false, fmt.Errorf("%q must be a >=0", value)
+	}
+	amount, err := dcrutil.NewAmount(dcr)
+	if err != nil {
+		return 0, false, err
+	}
+	if amount == 0 {
+		return 0, false, nil
+	}
+	return uint16(bits.Len64(uint64(amount)) - 1), true, nil
+}
+
+// encodeFeeLimits returns the fee limits of a ticket commitment.  The low byte
+// limits the vote fee and the high byte the revocation fee.
+func encodeFeeLimits(voteExp uint16, voteOK bool, revokeExp uint16, revokeOK bool) uint16 {
+	var limits uint16
+	if voteOK {
+		limits |= stake.SStxVoteFractionFlag | voteExp&stake.SStxVoteReturnFractionMask
+	}
+	if revokeOK {
+		limits |= stake.SStxRevFractionFlag |
+			(revokeExp<<8)&stake.SStxRevReturnFractionMask
+	}
+	return limits
+}
+
+// describeFeeLimit describes a fee limit of 2^exp atoms, or no fee when it is
+// not enabled.  Commitments can encode exponents that do not fit an amount,
+// those are only described in atoms.
+func describeFeeLimit(enabled bool, exp uint16) string {
+	if !enabled {
+		return "none"
+	}
+	if exp > maxFeeLimitExponent {
+		return fmt.Sprintf("up to 2^%d atoms", exp)
+	}
+	return fmt.Sprintf("up to %s (2^%d atoms)", dcrutil.Amount(uint64(1)<<exp), exp)
+}
+
+// describeFeeLimits decodes the fee limits of a ticket commitment.
+func describeFeeLimits(limits uint16) string {
+	voteFee := describeFeeLimit(limits&stake.SStxVoteFractionFlag != 0,
+		limits&stake.SStxVoteReturnFractionMask)
+	revokeFee := describeFeeLimit(limits&stake.SStxRevFractionFlag != 0,
+		(limits&stake.SStxRevReturnFractionMask)>>8)
+	return fmt.Sprintf("vote fee %s, revocation fee %s (0x%04x)", voteFee,
+		revokeFee, limits)
+}
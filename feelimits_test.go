@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFeeLimit(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantExp uint16
+		wantOK  bool
+		wantErr bool
+	}{
+		{value: "0"},
+		{value: "2^0", wantOK: true},
+		{value: "2^24", wantExp: 24, wantOK: true},
+		{value: "2^62", wantExp: 62, wantOK: true},
+		{value: "2^63", wantErr: true},
+		{value: "2^-1", wantErr: true},
+		{value: "0.00000001", wantOK: true},
+		// 0.17 DCR rounds down to 2^24 atoms.
+		{value: "0.17", wantExp: 24, wantOK: true},
+		{value: "-1", wantErr: true},
+		{value: "fee", wantErr: true},
+	}
+
+	for _, test := range tests {
+		exp, ok, err := parseFeeLimit(test.value)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.value, err)
+			continue
+		}
+		if exp != test.wantExp || ok != test.wantOK {
+			t.Errorf("%s: exponent %d enabled %v, want %d enabled %v", test.value,
+				exp, ok, test.wantExp, test.wantOK)
+		}
+	}
+}
+
+func TestDescribeFeeLimits(t *testing.T) {
+	// The largest exponents a commitment can encode, 63 does not fit an
+	// amount.
+	limits := encodeFeeLimits(63, true, 62, true)
+	description := describeFeeLimits(limits)
+	if !strings.Contains(description, "vote fee up to 2^63 atoms") ||
+		!strings.Contains(description, "(2^62 atoms)") {
+		t.Fatalf("unexpected description %q", description)
+	}
+	if strings.Contains(description, "-") {
+		t.Fatalf("description %q has a negative amount", description)
+	}
+
+	description = describeFeeLimits(encodeFeeLimits(0, false, 24, true))
+	if !strings.HasPrefix(description, "vote fee none, revocation fee up to ") {
+		t.Fatalf("unexpected description %q", description)
+	}
+}
@@ -2,68 +2,143 @@ package main
 
 import (
 	"bytes"
-	"crypto/tls"
-	"crypto/x509"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/decred/dcrd/dcrjson/v3"
 )
 
-func newHTTPClient(certificateFile string) (*http.Client, error) {
+const (
+	// maxRPCRetries is the number of times a JSON-RPC request failing with
+	// a transient network error is retried.
+	maxRPCRetries = 3
 
-	// Configure tls
-	tlsConfig := &tls.Config{
-		InsecureSkipVerify: false,
-	}
+	// rpcRetryDelay is the delay before the first retry, it doubles with
+	// every following retry.
+	rpcRetryDelay = 500 * time.Millisecond
+)
 
-	pem, err := ioutil.ReadFile(certificateFile)
+// jsonRPCClient sends JSON-RPC requests to the wallet.  It is created once
+// and reuses its connections for every request.
+type jsonRPCClient struct {
+	// id is the ID of the last request sent and must be accessed
+	// atomically.
+	id uint64
+
+	url        string
+	user       string
+	pass       string
+	timeout    time.Duration
+	httpClient *http.Client
+}
+
+func newJSONRPCClient(cfg *config) (*jsonRPCClient, error) {
+	httpClient, err := newHTTPClient(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	pool := x509.NewCertPool()
-	if ok := pool.AppendCertsFromPEM(pem); !ok {
-		return nil, fmt.Errorf("invalid certificate file: %v", certificateFile)
+	return &jsonRPCClient{
+		url:        "https://" + cfg.RPCServer,
+		user:       cfg.RPCUser,
+		pass:       cfg.RPCPass,
+		timeout:    cfg.RPCTimeout,
+		httpClient: httpClient,
+	}, nil
+}
+
+func newHTTPClient(cfg *config) (*http.Client, error) {
+
+	// Configure tls
+	tlsConfig, err := newTLSConfig(cfg, "")
+	if err != nil {
+		return nil, err
 	}
-	tlsConfig.RootCAs = pool
 
-	// Create and return the new HTTP client potentially configured with a
-	// proxy and TLS.
-	var dial func(network, addr string) (net.Conn, error)
 	client := http.Client{
 		Transport: &http.Transport{
-			Dial:            dial,
-			TLSClientConfig: tlsConfig,
+			Proxy:               http.ProxyFromEnvironment,
+			TLSClientConfig:     tlsConfig,
+			MaxIdleConnsPerHost: 2,
+			IdleConnTimeout:     90 * time.Second,
 		},
 	}
 	return &client, nil
 }
 
-func sendPostRequest(jsonRPCServer, rpcUser, rpcPass string, marshalledJSON []byte) (*dcrjson.Response, error) {
+// call sends cmd to the wallet and unmarshals the result into result, which
+// may be nil when the result is not needed.  Every attempt is bounded by the
+// configured RPC timeout and requests failing with a transient network error
+// are retried.  Errors returned by the wallet are returned as
+// *dcrjson.RPCError.
+func (c *jsonRPCClient) call(ctx context.Context, cmd interface{}, result interface{}) error {
+	id := atomic.AddUint64(&c.id, 1)
+	marshalledJSON, err := dcrjson.MarshalCmd(rpcVersion, id, cmd)
+	if err != nil {
+		return err
+	}
+
+	var resp *dcrjson.Response
+	delay := rpcRetryDelay
+	for attempt := 0; ; attempt++ {
+		resp, err = c.sendPostRequest(ctx, marshalledJSON)
+		if err == nil || attempt == maxRPCRetries || ctx.Err() != nil ||
+			!isTransientError(err) {
+			break
+		}
+
+		fmt.Printf("JSON-RPC request failed: %v, retrying in %s\n", err, delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+	if err != nil {
+		return err
+	}
+
+	if resp.Error != nil {
+		return resp.Error
+	}
+
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(resp.Result, result)
+}
+
+func (c *jsonRPCClient) sendPostRequest(ctx context.Context, marshalledJSON []byte) (*dcrjson.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
 	bodyReader := bytes.NewReader(marshalledJSON)
-	req, err := http.NewRequest("POST", "https://"+jsonRPCServer, bodyReader)
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url, bodyReader)
 	if err != nil {
 		return nil, err
 	}
-	req.Close = true
 	req.Header.Set("Content-Type", "application/json")
-	req.SetBasicAuth(rpcUser, rpcPass)
+	req.SetBasicAuth(c.user, c.pass)
 
-	client, err := newHTTPClient(certificateFile)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
-
-	resp, err := client.Do(req)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
 	if err != nil {
 		return nil, err
 	}
-	body, _ := ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
 
 	if resp.StatusCode == 200 {
 		var resp dcrjson.Response
@@ -81,3 +156,17 @@ func sendPostRequest(jsonRPCServer, rpcUser, rpcPass string, marshalledJSON []by
 
 	return nil, fmt.Errorf("%s", body)
 }
+
+// isTransientError returns whether err is a network error that may not occur
+// again when the request is retried.
+func isTransientError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && (netErr.Timeout() || netErr.Temporary()) {
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/dcrutil/v2"
+	"github.com/decred/dcrd/wire"
+)
+
+const defaultJournalFilename = "purchases.json"
+
+// purchaseStage is the progress of a single ticket purchase.
+type purchaseStage string
+
+const (
+	// stageFundingBuilt means the funding transaction was built and is
+	// about to be signed and published.  It may or may not have been
+	// published.
+	stageFundingBuilt purchaseStage = "fundingbuilt"
+
+	// stageFundingPublished means the funding transaction was published
+	// and the ticket was not built yet.
+	stageFundingPublished purchaseStage = "fundingpublished"
+
+	// stageTicketBuilt means the ticket was built and is about to be
+	// signed and published.  It may or may not have been published.
+	stageTicketBuilt purchaseStage = "ticketbuilt"
+
+	// stageTicketPublished means the ticket was published.
+	stageTicketPublished purchaseStage = "ticketpublished"
+
+	// stageReclaimed means the funding output was released back to the
+	// source account without buying a ticket.
+	stageReclaimed purchaseStage = "reclaimed"
+)
+
+// done returns whether no further work is needed for a ticket in stage s.
+func (s purchaseStage) done() bool {
+	return s == stageTicketPublished || s == stageReclaimed
+}
+
+// journalTicket is a ticket funded by outputs of a funding transaction.
+type journalTicket struct {
+	FundingOutput uint32         `json:"fundingoutput"`
+	Amount        dcrutil.Amount `json:"amount"`
+	PoolFeeOutput *uint32        `json:"poolfeeoutput,omitempty"`
+	PoolFeeAmount dcrutil.Amount `json:"poolfeeamount,omitempty"`
+	Stage         purchaseStage  `json:"stage"`
+	TicketHash    string         `json:"tickethash,omitempty"`
+}
+
+// funding returns the funding of the ticket by outputs of the funding
+// transaction with hash fundingHash.
+func (t *journalTicket) funding(fundingHash *chainhash.Hash) *ticketFunding {
+	f := &ticketFunding{
+		outPoint: wire.NewOutPoint(fundingHash, t.FundingOutput, wire.TxTreeRegular),
+		amount:   t.Amount,
+	}
+	if t.PoolFeeOutput != nil {
+		f.poolFeeOutPoint = wire.NewOutPoint(fundingHash, *t.PoolFeeOutput,
+			wire.TxTreeRegular)
+		f.poolFeeAmount = t.PoolFeeAmount
+	}
+	return f
+}
+
+// journalPurchase is a funding transaction and the tickets it funds.
+type journalPurchase struct {
+	FundingHash string           `json:"fundinghash"`
+	Created     time.Time        `json:"created"`
+	Tickets     []*journalTicket `json:"tickets"`
+}
+
+// done returns whether every ticket of the purchase is done.
+func (p *journalPurchase) done() bool {
+	for _, ticket := range p.Tickets {
+		if !ticket.Stage.done() {
+			return false
+		}
+	}
+	return true
+}
+
+// purchaseJournal records the stage of in-flight ticket purchases in a JSON
+// file so purchases interrupted by a crash can be completed or reclaimed on
+// the next start.  Purchases are removed once every ticket is done.
+type purchaseJournal struct {
+	path      string
+	purchases []*journalPurchase
+}
+
+// openPurchaseJournal loads the journal at path.  A missing file is an empty
+// journal.
+func openPurchaseJournal(path string) (*purchaseJournal, error) {
+	j := &purchaseJournal{path: path}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, &j.purchases)
+	if err != nil {
+		return nil, fmt.Errorf("invalid purchase journal %s: %v", path, err)
+	}
+	return j, nil
+}
+
+// pending returns the purchases with tickets that are not done.
+func (j *purchaseJournal) pending() []*journalPurchase {
+	pending := make([]*journalPurchase, len(j.purchases))
+	copy(pending, j.purchases)
+	return pending
+}
+
+// reserved returns the funding outputs of tickets that are not done.  They
+// must not be spent by anything but their ticket.
+func (j *purchaseJournal) reserved() map[wire.OutPoint]bool {
+	outPoints := make(map[wire.OutPoint]bool)
+	for _, purchase := range j.purchases {
+		fundingHash, err := chainhash.NewHashFromStr(purchase.FundingHash)
+		if err != nil {
+			continue
+		}
+		for _, ticket := range purchase.Tickets {
+			if ticket.Stage.done() {
+				continue
+			}
+			funding := ticket.funding(fundingHash)
+			outPoints[*funding.outPoint] = true
+			if funding.poolFeeOutPoint != nil {
+				outPoints[*funding.poolFeeOutPoint] = true
+			}
+		}
+	}
+	return outPoints
+}
+
+// addPurchase records a funding transaction in stageFundingBuilt.  fundings
+// are the fundings of the tickets by outputs of the funding transaction.
+func (j *purchaseJournal) addPurchase(fundingHash *chainhash.Hash, fundings []*ticketFunding) (*journalPurchase, error) {
+	purchase := &journalPurchase{
+		FundingHash: fundingHash.String(),
+		Created:     time.Now(),
+		Tickets:     make([]*journalTicket, 0, len(fundings)),
+	}
+	for _, funding := range fundings {
+		ticket := &journalTicket{
+			FundingOutput: funding.outPoint.Index,
+			Amount:        funding.amount,
+			Stage:         stageFundingBuilt,
+		}
+		if funding.poolFeeOutPoint != nil {
+			poolFeeOutput := funding.poolFeeOutPoint.Index
+			ticket.PoolFeeOutput = &poolFeeOutput
+			ticket.PoolFeeAmount = funding.poolFeeAmount
+		}
+		purchase.Tickets = append(purchase.Tickets, ticket)
+	}
+
+	j.purchases = append(j.purchases, purchase)
+	return purchase, j.save()
+}
+
+// setFundingStage moves every ticket of purchase to stage.
+func (j *purchaseJournal) setFundingStage(purchase *journalPurchase, stage purchaseStage) error {
+	for _, ticket := range purchase.Tickets {
+		ticket.Stage = stage
+	}
+	return j.save()
+}
+
+// setTicketStage moves ticket of purchase to stage.  A nil ticketHash keeps the
+// recorded ticket hash.  The purchase is removed from the journal once every
+// ticket is done.
+func (j *purchaseJournal) setTicketStage(purchase *journalPurchase, ticket *journalTicket, stage purchaseStage, ticketHash *chainhash.Hash) error {
+	ticket.Stage = stage
+	if ticketHash != nil {
+		ticket.TicketHash = ticketHash.String()
+	}
+
+	if purchase.done() {
+		for i, p := range j.purchases {
+			if p == purchase {
+				j.purchases = append(j.purchases[:i], j.purchases[i+1:]...)
+				break
+			}
+		}
+	}
+	return j.save()
+}
+
+// save writes the journal to its file.
+func (j *purchaseJournal) save() error {
+	return writeJSONFile(j.path, j.purchases)
+}
+
+// writeJSONFile writes v as JSON to a temporary file which then replaces the
+// file at path, so a crash never leaves a partially written file behind.
+func writeJSONFile(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	err = ioutil.WriteFile(tmpPath, b, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// readJSONFile unmarshals the JSON file at path into v.  A missing file leaves
+// v unchanged.
+func readJSONFile(path string, v interface{}) error {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
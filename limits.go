@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/decred/dcrd/dcrutil/v2"
+)
+
+const defaultSpendLimitsFilename = "spendlimits.json"
+
+// ticketsPeriod is the period limited by --maxticketsperday.
+const ticketsPeriod = 24 * time.Hour
+
+// spendRecord is a spending of the source account.
+type spendRecord struct {
+	Time    int64          `json:"time"`
+	Amount  dcrutil.Amount `json:"amount"`
+	Tickets int            `json:"tickets,omitempty"`
+	Window  int64          `json:"window,omitempty"`
+}
+
+// spendLimits enforces the configured spending limits.  Every transaction
+// published is recorded on disk, so the limits hold across restarts.  Tickets
+// are recorded together with their funding transaction, so tickets waiting
+// for funding confirmations count as well.  A zero limit is disabled.
+type spendLimits struct {
+	maxTicketCost       dcrutil.Amount
+	maxTicketsPerDay    int
+	maxTicketsPerWindow int
+	maxSpend            dcrutil.Amount
+	spendPeriod         time.Duration
+	maxFeePercent       float64
+
+	path    string
+	records []spendRecord
+}
+
+// openSpendLimits returns the configured spending limits along with the
+// spendings recorded at cfg.spendLimitsFile.
+func openSpendLimits(cfg *config) (*spendLimits, error) {
+	l := &spendLimits{
+		maxTicketCost:       cfg.maxTicketCost,
+		maxTicketsPerDay:    cfg.MaxTicketsPerDay,
+		maxTicketsPerWindow: cfg.MaxWindowTickets,
+		maxSpend:            cfg.maxSpend,
+		spendPeriod:         cfg.SpendPeriod,
+		maxFeePercent:       cfg.MaxFeePercent,
+		path:                cfg.spendLimitsFile,
+	}
+	err := readJSONFile(l.path, &l.records)
+	if err != nil {
+		return nil, fmt.Errorf("invalid spending records %s: %v", l.path, err)
+	}
+	return l, nil
+}
+
+// limitError is returned when a spending limit blocks a transaction.
+type limitError struct {
+	flag    string
+	message string
+}
+
+func (e *limitError) Error() string {
+	return fmt.Sprintf("spending limit --%s reached: %s", e.flag, e.message)
+}
+
+// spentSince returns the amount spent and the number of tickets purchased
+// since t.
+func (l *spendLimits) spentSince(t time.Time) (dcrutil.Amount, int) {
+	var amount dcrutil.Amount
+	var tickets int
+	for _, r := range l.records {
+		if r.Time < t.Unix() {
+			continue
+		}
+		amount += r.Amount
+		tickets += r.Tickets
+	}
+	return amount, tickets
+}
+
+// windowTickets returns the number of tickets purchased in the stake
+// difficulty window.
+func (l *spendLimits) windowTickets(window int64) int {
+	var tickets int
+	for _, r := range l.records {
+		if r.Tickets != 0 && r.Window == window {
+			tickets += r.Tickets
+		}
+	}
+	return tickets
+}
+
+// checkSpend checks that spending amount stays within the rolling spending
+// limit.
+func (l *spendLimits) checkSpend(amount dcrutil.Amount) error {
+	if l.maxSpend == 0 {
+		return nil
+	}
+	spent, _ := l.spentSince(time.Now().Add(-l.spendPeriod))
+	if spent+amount > l.maxSpend {
+		return &limitError{"maxspend", fmt.Sprintf("spending %s after %s "+
+			"in the last %s exceeds %s", amount, spent, l.spendPeriod, l.maxSpend)}
+	}
+	return nil
+}
+
+// checkFee checks that fee is within the maximum fee percentage of amount.
+func (l *spendLimits) checkFee(fee, amount dcrutil.Amount) error {
+	if l.maxFeePercent == 0 {
+		return nil
+	}
+	if amount <= 0 || float64(fee)*100/float64(amount) > l.maxFeePercent {
+		return &limitError{"maxfeepercent", fmt.Sprintf("fee of %s exceeds "+
+			"%.2f%% of %s", fee, l.maxFeePercent, amount)}
+	}
+	return nil
+}
+
+// checkTransaction checks a regular transaction paying amount with fee.
+func (l *spendLimits) checkTransaction(amount, fee dcrutil.Amount) error {
+	err := l.checkFee(fee, amount)
+	if err != nil {
+		return err
+	}
+	return l.checkSpend(amount + fee)
+}
+
+// checkTicket checks the cost of a single ticket costing ticketPrice plus
+// ticketFee.
+func (l *spendLimits) checkTicket(ticketPrice, ticketFee dcrutil.Amount) error {
+	ticketCost := ticketPrice + ticketFee
+	if l.maxTicketCost != 0 && ticketCost > l.maxTicketCost {
+		return &limitError{"maxticketcost", fmt.Sprintf("ticket cost of %s "+
+			"exceeds %s", ticketCost, l.maxTicketCost)}
+	}
+	return l.checkFee(ticketFee, ticketPrice)
+}
+
+// checkTickets checks the purchase of numTickets tickets in window, every
+// ticket costing ticketPrice plus ticketFee, funded by a transaction paying
+// fundingFee.
+func (l *spendLimits) checkTickets(numTickets int, ticketPrice, ticketFee, fundingFee dcrutil.Amount, window int64) error {
+	err := l.checkTicket(ticketPrice, ticketFee)
+	if err != nil {
+		return err
+	}
+
+	if l.maxTicketsPerDay != 0 {
+		_, tickets := l.spentSince(time.Now().Add(-ticketsPeriod))
+		if tickets+numTickets > l.maxTicketsPerDay {
+			return &limitError{"maxticketsperday", fmt.Sprintf("%d ticket(s) "+
+				"after %d in the last %s exceed %d", numTickets, tickets,
+				ticketsPeriod, l.maxTicketsPerDay)}
+		}
+	}
+
+	if l.maxTicketsPerWindow != 0 {
+		tickets := l.windowTickets(window)
+		if tickets+numTickets > l.maxTicketsPerWindow {
+			return &limitError{"maxticketsperwindow", fmt.Sprintf("%d ticket(s) "+
+				"after %d in this stake difficulty window exceed %d", numTickets,
+				tickets, l.maxTicketsPerWindow)}
+		}
+	}
+
+	ticketsCost := dcrutil.Amount(numTickets) * (ticketPrice + ticketFee)
+	if fundingFee != 0 {
+		err := l.checkFee(fundingFee, ticketsCost)
+		if err != nil {
+			return err
+		}
+	}
+	return l.checkSpend(ticketsCost + fundingFee)
+}
+
+// recordTransaction records a published regular transaction paying amount
+// with fee.
+func (l *spendLimits) recordTransaction(amount, fee dcrutil.Amount) error {
+	return l.record(spendRecord{Amount: amount + fee})
+}
+
+// recordTickets records numTickets tickets of window, funded by a published
+// funding transaction, that cost amount including the funding fee.
+func (l *spendLimits) recordTickets(numTickets int, amount dcrutil.Amount, window int64) error {
+	return l.record(spendRecord{Amount: amount, Tickets: numTickets, Window: window})
+}
+
+// record adds r to the spending records and drops the records no limit
+// looks at anymore.
+func (l *spendLimits) record(r spendRecord) error {
+	now := time.Now()
+	r.Time = now.Unix()
+
+	keep := ticketsPeriod
+	if l.spendPeriod > keep {
+		keep = l.spendPeriod
+	}
+	cutoff := now.Add(-keep).Unix()
+
+	// Tickets of the current window are kept for the window limit, stake
+	// difficulty windows last less than a day on every network.
+	records := l.records[:0]
+	for _, old := range l.records {
+		if old.Time >= cutoff {
+			records = append(records, old)
+		}
+	}
+	l.records = append(records, r)
+	return writeJSONFile(l.path, l.records)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/decred/dcrd/dcrutil/v2"
+)
+
+// checkLimitError checks that err is nil when wantFlag is empty and a limit
+// error of the flag wantFlag otherwise.
+func checkLimitError(t *testing.T, err error, wantFlag string) {
+	t.Helper()
+
+	if wantFlag == "" {
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	limitErr, ok := err.(*limitError)
+	if !ok {
+		t.Fatalf("error %v, want a limit error of --%s", err, wantFlag)
+	}
+	if limitErr.flag != wantFlag {
+		t.Fatalf("limit --%s reached, want --%s", limitErr.flag, wantFlag)
+	}
+}
+
+// spentAgo returns a record of amount and tickets spent ago in window.
+func spentAgo(ago time.Duration, amount dcrutil.Amount, tickets int, window int64) spendRecord {
+	return spendRecord{
+		Time:    time.Now().Add(-ago).Unix(),
+		Amount:  amount,
+		Tickets: tickets,
+		Window:  window,
+	}
+}
+
+func TestCheckTickets(t *testing.T) {
+	const (
+		price  = 100 * dcrutil.AtomsPerCoin
+		fee    = 1e5
+		cost   = price + fee
+		window = 5
+	)
+
+	tests := []struct {
+		name       string
+		limits     spendLimits
+		records    []spendRecord
+		numTickets int
+		fundingFee dcrutil.Amount
+		window     int64
+		wantFlag   string
+	}{{
+		name:       "no limits",
+		numTickets: 10,
+		fundingFee: 1e6,
+	}, {
+		name:       "ticket cost",
+		limits:     spendLimits{maxTicketCost: cost - 1},
+		numTickets: 1,
+		wantFlag:   "maxticketcost",
+	}, {
+		name:       "ticket fee",
+		limits:     spendLimits{maxFeePercent: 0.0001},
+		numTickets: 1,
+		wantFlag:   "maxfeepercent",
+	}, {
+		name:       "funding fee",
+		limits:     spendLimits{maxFeePercent: 1},
+		numTickets: 2,
+		fundingFee: 2 * cost / 50,
+		wantFlag:   "maxfeepercent",
+	}, {
+		name:   "tickets per day",
+		limits: spendLimits{maxTicketsPerDay: 3},
+		records: []spendRecord{
+			spentAgo(time.Hour, 2*cost, 2, window-1),
+		},
+		numTickets: 2,
+		wantFlag:   "maxticketsperday",
+	}, {
+		name:   "tickets of the previous day",
+		limits: spendLimits{maxTicketsPerDay: 3},
+		records: []spendRecord{
+			spentAgo(25*time.Hour, 2*cost, 2, window-1),
+		},
+		numTickets: 3,
+	}, {
+		name:   "tickets per window",
+		limits: spendLimits{maxTicketsPerWindow: 2},
+		records: []spendRecord{
+			spentAgo(time.Minute, cost, 1, window),
+			spentAgo(time.Minute, 1e4, 0, 0),
+			spentAgo(time.Hour, cost, 1, window-1),
+		},
+		numTickets: 2,
+		wantFlag:   "maxticketsperwindow",
+	}, {
+		name:   "tickets of the previous window",
+		limits: spendLimits{maxTicketsPerWindow: 2},
+		records: []spendRecord{
+			spentAgo(time.Hour, 2*cost, 2, window-1),
+		},
+		numTickets: 2,
+	}, {
+		name:       "spending",
+		limits:     spendLimits{maxSpend: 2 * cost},
+		numTickets: 2,
+	}, {
+		name:       "spending with funding fee",
+		limits:     spendLimits{maxSpend: 2 * cost},
+		numTickets: 2,
+		fundingFee: 1e4,
+		wantFlag:   "maxspend",
+	}, {
+		name:   "spending with earlier spending",
+		limits: spendLimits{maxSpend: 3 * cost},
+		records: []spendRecord{
+			spentAgo(time.Hour, cost, 1, window-1),
+			spentAgo(time.Minute, 1e4, 0, 0),
+		},
+		numTickets: 2,
+		wantFlag:   "maxspend",
+	}, {
+		name:   "spending before the period",
+		limits: spendLimits{maxSpend: 3 * cost},
+		records: []spendRecord{
+			spentAgo(25*time.Hour, cost, 1, window-1),
+		},
+		numTickets: 2,
+		fundingFee: 1e4,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := test.limits
+			l.spendPeriod = defaultSpendPeriod
+			l.records = test.records
+
+			err := l.checkTickets(test.numTickets, price, fee, test.fundingFee, window)
+			checkLimitError(t, err, test.wantFlag)
+		})
+	}
+}
+
+func TestCheckTransaction(t *testing.T) {
+	tests := []struct {
+		name     string
+		limits   spendLimits
+		amount   dcrutil.Amount
+		fee      dcrutil.Amount
+		wantFlag string
+	}{{
+		name:   "within limits",
+		limits: spendLimits{maxSpend: 1e8, maxFeePercent: 1},
+		amount: 1e8 - 1e4,
+		fee:    1e4,
+	}, {
+		name:     "spending",
+		limits:   spendLimits{maxSpend: 1e8},
+		amount:   1e8,
+		fee:      1e4,
+		wantFlag: "maxspend",
+	}, {
+		name:     "fee",
+		limits:   spendLimits{maxFeePercent: 1},
+		amount:   1e6,
+		fee:      1e4 + 1,
+		wantFlag: "maxfeepercent",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := test.limits
+			l.spendPeriod = defaultSpendPeriod
+
+			err := l.checkTransaction(test.amount, test.fee)
+			checkLimitError(t, err, test.wantFlag)
+		})
+	}
+}
+
+func TestRecordSpending(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	cfg := newTestConfig(dir)
+	cfg.MaxTicketsPerDay = 2
+	cfg.maxSpend = 10e8
+	l, err := openSpendLimits(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Records no limit looks at anymore are dropped.
+	l.records = []spendRecord{spentAgo(25*time.Hour, 5e8, 1, 1)}
+
+	err = l.recordTickets(1, 3e8, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.recordTransaction(5e8-1e4, 1e4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The spending survives a restart.
+	l, err = openSpendLimits(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.records) != 2 {
+		t.Fatalf("%d spending records, want 2", len(l.records))
+	}
+	spent, tickets := l.spentSince(time.Now().Add(-time.Hour))
+	if want := dcrutil.Amount(8e8); spent != want || tickets != 1 {
+		t.Fatalf("spent %s on %d tickets, want %s on 1", spent, tickets, want)
+	}
+
+	// 2 DCR of the 10 DCR are left to spend.
+	err = l.checkTickets(1, 2e8-1e4, 1e4, 0, 2)
+	checkLimitError(t, err, "")
+	err = l.checkTickets(2, 0.5e8, 1e4, 0, 2)
+	checkLimitError(t, err, "maxticketsperday")
+	err = l.checkTickets(1, 2e8-1e4, 1e4, 1e4, 2)
+	checkLimitError(t, err, "maxspend")
+}
@@ -1,36 +1,29 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"path/filepath"
 
-	"github.com/decred/dcrd/chaincfg/v2"
 	"github.com/decred/dcrd/dcrutil/v2"
+	"github.com/decred/dcrd/wire"
 	pb "github.com/decred/dcrwallet/rpc/walletrpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 )
 
 const (
-	// defaultTicketFeeLimits is the default byte string for the default
-	// fee limits imposed on a ticket.
-	defaultTicketFeeLimits = 0x5800
-
-	defaultExpiry         = int32(0)
-	requiredConfirmations = 0
-	accountNumber         = 0 // default account
-	rpcVersion            = "1.0"
+	accountNumber = 0 // default account
+	rpcVersion    = "1.0"
 
 	sendTxCmd         = "sendtx"
 	purchaseTicketCmd = "purchaseticket"
+	stakeInfoCmd      = "stakeinfo"
 
 	// send ticket config
 	sourceAccount = 0
 	changeAccount = 0
 )
 
-var certificateFile = filepath.Join(dcrutil.AppDataDir("dcrwallet", false), "rpc.cert")
-
 func main() {
 
 	cfg, err := loadConfig()
@@ -38,24 +31,101 @@ func main() {
 		fmt.Println(err)
 		return
 	}
+	defer zeroBytes(cfg.walletPass)
 
-	conn, err := connect(cfg.GRPCServer)
+	conn, err := connect(cfg)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 	defer conn.Close()
 
-	var activeNet *chaincfg.Params
-	if cfg.Network == chaincfg.TestNet3Params().Name {
-		activeNet = chaincfg.TestNet3Params()
-	} else {
-		activeNet = chaincfg.MainNetParams()
+	walletService := pb.NewWalletServiceClient(conn)
+	err = checkWalletNetwork(cfg.params, walletService)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	accounts, err := walletAccounts(context.Background(), walletService)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	err = resolveAccounts(cfg, accounts)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	querier, err := newWalletQuerier(cfg, walletService)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	wallet := newWalletClient(walletService, querier, cfg.params)
+
+	if cfg.StakeInfo {
+		err = printStakeInfo(wallet, cfg.params.Params)
+		if err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	limits, err := openSpendLimits(cfg)
+	if err != nil {
+		fmt.Println(err)
+		return
 	}
 
 	if cfg.PurchaseTicket {
 
-		tb := NewTicketBuyer(cfg, conn, chaincfg.TestNet3Params())
+		journal, err := openPurchaseJournal(cfg.JournalFile)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		var vsp *vspManager
+		if cfg.VSPURL != "" {
+			vsp, err = newVSPManager(context.Background(), cfg)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+
+		var votingXPub *xpubVotingAddresses
+		if cfg.VotingXPub != "" {
+			votingXPub, err = newXPubVotingAddresses(cfg.VotingXPub,
+				cfg.votingXPubFile, cfg.DryRun, cfg.params.Params)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+
+		var strategy Strategy
+		if cfg.Daemon {
+			strategy, err = newStrategy(cfg)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+
+		tb := NewTicketBuyer(cfg, wallet, journal, vsp, votingXPub, strategy,
+			limits, cfg.params.Params)
+
+		if cfg.Daemon {
+			err = tb.run(shutdownListener())
+			if err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
 
 		err = tb.updateFees()
 		if err != nil {
@@ -63,14 +133,26 @@ func main() {
 			return
 		}
 
-		err = tb.purchaseTicket()
+		err = tb.resumePurchases()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		// Unpaid VSP fees do not prevent new purchases.
+		err = tb.processVSPTickets()
+		if err != nil {
+			fmt.Println(err)
+		}
+
+		_, err = tb.purchaseTickets(cfg.NumTickets)
+		zeroBytes(cfg.walletPass)
 		if err != nil {
 			fmt.Println(err)
 			return
 		}
 	} else {
-		walletService := pb.NewWalletServiceClient(conn)
-		addr, err := dcrutil.DecodeAddress(cfg.DestinationAddress, activeNet)
+		addr, err := dcrutil.DecodeAddress(cfg.DestinationAddress, cfg.params)
 		if err != nil {
 			fmt.Println(err)
 			return
@@ -82,13 +164,25 @@ func main() {
 			return
 		}
 
-		_, changeScript, err := generateAddress(true, cfg.SourceAccount, activeNet, walletService)
+		_, changeScript, err := generateAddress(true, cfg.changeAccount, wallet)
 		if err != nil {
 			fmt.Println(err)
 			return
 		}
 
-		utxos, err := listUnspentOutputs(cfg)
+		// Outputs reserved by pending ticket purchases and VSP fee
+		// payments are not spent.
+		journal, err := openPurchaseJournal(cfg.JournalFile)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		vspTickets, err := openVSPTicketStore(cfg.vspTicketsFile)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		utxos, err := unreservedOutputs(cfg, wallet, journal, vspTickets)
 		if err != nil {
 			fmt.Println(err)
 			return
@@ -100,8 +194,17 @@ func main() {
 			return
 		}
 
-		rt := NewRegularTransaction(cfg, outputScript, changeScript, amount, utxos, walletService)
+		err = updateTxRelayFee(wallet)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		outputs := []*wire.TxOut{wire.NewTxOut(int64(amount), outputScript)}
+		rt := NewRegularTransaction(cfg, outputs, changeScript, utxos, wallet)
+		rt.limits = limits
 		_, err = rt.broadcastTransaction()
+		zeroBytes(cfg.walletPass)
 		if err != nil {
 			fmt.Println(err)
 			return
@@ -111,17 +214,19 @@ func main() {
 }
 
 func printUsage() {
-	fmt.Printf("Usage:\nticketbuyer %s | %s\n", sendTxCmd, purchaseTicketCmd)
+	fmt.Printf("Usage:\nticketbuyer %s | %s | %s\n", sendTxCmd, purchaseTicketCmd,
+		stakeInfoCmd)
 }
 
-func connect(grpcServer string) (*grpc.ClientConn, error) {
+func connect(cfg *config) (*grpc.ClientConn, error) {
 
-	creds, err := credentials.NewClientTLSFromFile(certificateFile, "localhost")
+	tlsConfig, err := newTLSConfig(cfg, cfg.GRPCServerName)
 	if err != nil {
 		return nil, err
 	}
+	creds := credentials.NewTLS(tlsConfig)
 
-	conn, err := grpc.Dial(grpcServer, grpc.WithTransportCredentials(creds))
+	conn, err := grpc.Dial(cfg.GRPCServer, grpc.WithTransportCredentials(creds))
 	if err != nil {
 		return nil, err
 	}
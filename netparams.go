@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/decred/dcrd/chaincfg/v2"
+	"github.com/decred/dcrd/wire"
+	pb "github.com/decred/dcrwallet/rpc/walletrpc"
+)
+
+// netParams couples the chain parameters of a network with the ports
+// dcrwallet listens on by default for that network.
+type netParams struct {
+	*chaincfg.Params
+	JSONRPCServerPort string
+	GRPCServerPort    string
+}
+
+var (
+	mainNetParams = netParams{
+		Params:            chaincfg.MainNetParams(),
+		JSONRPCServerPort: "9110",
+		GRPCServerPort:    "9111",
+	}
+	testNet3Params = netParams{
+		Params:            chaincfg.TestNet3Params(),
+		JSONRPCServerPort: "19110",
+		GRPCServerPort:    "19111",
+	}
+	simNetParams = netParams{
+		Params:            chaincfg.SimNetParams(),
+		JSONRPCServerPort: "19557",
+		GRPCServerPort:    "19558",
+	}
+	regNetParams = netParams{
+		Params:            chaincfg.RegNetParams(),
+		JSONRPCServerPort: "18557",
+		GRPCServerPort:    "18558",
+	}
+
+	supportedNetworks = []*netParams{&mainNetParams, &testNet3Params,
+		&simNetParams, &regNetParams}
+)
+
+// resolveNetwork returns the parameters of the network with the given name.
+func resolveNetwork(name string) (*netParams, error) {
+	names := make([]string, 0, len(supportedNetworks))
+	for _, params := range supportedNetworks {
+		if params.Name == name {
+			return params, nil
+		}
+		names = append(names, params.Name)
+	}
+
+	return nil, fmt.Errorf("unknown network %q, must be one of %s", name,
+		strings.Join(names, ", "))
+}
+
+// checkWalletNetwork returns an error when the wallet is not running on the
+// network described by params.
+func checkWalletNetwork(params *netParams, walletService pb.WalletServiceClient) error {
+	ctx := context.Background()
+	networkResponse, err := walletService.Network(ctx, &pb.NetworkRequest{})
+	if err != nil {
+		return err
+	}
+
+	walletNet := wire.CurrencyNet(networkResponse.ActiveNetwork)
+	if walletNet != params.Net {
+		return fmt.Errorf("wallet is running on %s, not %s", walletNet,
+			params.Name)
+	}
+
+	return nil
+}
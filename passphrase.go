@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// walletPassEnvVar is the environment variable the wallet passphrase is read
+// from when it is not provided by the config.
+const walletPassEnvVar = "TICKETBUYER_WALLETPASS"
+
+// loadWalletPassphrase returns the wallet passphrase from the first available
+// source, in order: --walletpass, --walletpassfile, the TICKETBUYER_WALLETPASS
+// environment variable and finally an interactive prompt when stdin is a
+// terminal.
+//
+// A single purchase or transaction zeroes the returned passphrase once it is
+// done.  The daemon signs with it for every purchase, so it is kept until the
+// process exits unless it is read from --walletpassfile, see
+// config.walletPassphrase.  A passphrase given with --walletpass also remains
+// in the string it was parsed into, which can not be cleared, as well as in
+// the process list or config file.
+func loadWalletPassphrase(cfg *config) ([]byte, error) {
+	if cfg.WalletPassphrase != "" {
+		fmt.Println("Warning: --walletpass exposes the wallet passphrase in the " +
+			"process list or config file and in memory that can not be cleared, " +
+			"prefer --walletpassfile, the " + walletPassEnvVar + " environment " +
+			"variable or the interactive prompt")
+		pass := []byte(cfg.WalletPassphrase)
+		cfg.WalletPassphrase = ""
+		return pass, nil
+	}
+
+	if cfg.WalletPassFile != "" {
+		return readPassphraseFile(cfg.WalletPassFile)
+	}
+
+	if pass, ok := os.LookupEnv(walletPassEnvVar); ok {
+		// Keep the passphrase out of the environment of any child
+		// process.
+		os.Unsetenv(walletPassEnvVar)
+		return []byte(pass), nil
+	}
+
+	stdin := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(stdin) {
+		return nil, fmt.Errorf("wallet passphrase must be set")
+	}
+
+	fmt.Print("Wallet passphrase: ")
+	pass, err := terminal.ReadPassword(stdin)
+	fmt.Println()
+	if err != nil {
+		return nil, err
+	}
+	return pass, nil
+}
+
+// walletPassphrase returns a copy of the wallet passphrase to sign with, which
+// the caller zeroes after use.  A daemon reading the passphrase from
+// --walletpassfile does not keep it in memory and reads the file again for
+// every signing.
+func (cfg *config) walletPassphrase() ([]byte, error) {
+	if cfg.walletPass == nil && cfg.WalletPassFile != "" {
+		return readPassphraseFile(cfg.WalletPassFile)
+	}
+	return append([]byte(nil), cfg.walletPass...), nil
+}
+
+// readPassphraseFile reads the passphrase stored in path, ignoring a trailing
+// newline.  The file must not be accessible by users other than its owner.
+func readPassphraseFile(path string) ([]byte, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("wallet passphrase file %s has permissions %v, "+
+			"it must only be accessible by its owner (chmod 600)", path,
+			fi.Mode().Perm())
+	}
+
+	pass, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	trimmed := bytes.TrimRight(pass, "\r\n")
+	if len(trimmed) == 0 {
+		return nil, fmt.Errorf("wallet passphrase file %s is empty", path)
+	}
+	return trimmed, nil
+}
+
+// zeroBytes overwrites b with zeros.
+func zeroBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
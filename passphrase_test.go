@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestWalletPassphrase(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	// A passphrase kept in the config is handed out as a copy.
+	cfg := &config{walletPass: []byte(testPassphrase)}
+	pass, err := cfg.walletPassphrase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	zeroBytes(pass)
+	if string(cfg.walletPass) != testPassphrase {
+		t.Fatalf("zeroing the returned passphrase cleared the config")
+	}
+
+	// Without a kept passphrase the file is read for every call.
+	path := filepath.Join(dir, "walletpass")
+	err = ioutil.WriteFile(path, []byte(testPassphrase+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg = &config{WalletPassFile: path}
+	pass, err = cfg.walletPassphrase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pass) != testPassphrase {
+		t.Fatalf("passphrase %q, want %q", pass, testPassphrase)
+	}
+
+	err = ioutil.WriteFile(path, []byte("changed"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pass, err = cfg.walletPassphrase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pass) != "changed" {
+		t.Fatalf("passphrase %q, want the changed file contents", pass)
+	}
+}
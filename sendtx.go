@@ -1,197 +1,263 @@
 package main
 
 import (
-	"encoding/hex"
+	"context"
 	"fmt"
-	"math/rand"
-	"time"
 
 	"github.com/decred/dcrd/chaincfg/chainhash"
 	"github.com/decred/dcrd/dcrutil/v2"
 	"github.com/decred/dcrd/txscript/v2"
 	"github.com/decred/dcrd/wire"
 	"github.com/decred/dcrwallet/errors/v2"
-	wallettypes "github.com/decred/dcrwallet/rpc/jsonrpc/types"
-	pb "github.com/decred/dcrwallet/rpc/walletrpc"
-	"github.com/decred/dcrwallet/wallet/v3/txauthor"
 	"github.com/decred/dcrwallet/wallet/v3/txrules"
 	"github.com/decred/dcrwallet/wallet/v3/txsizes"
 )
 
+// outputRole describes why an output was added to a regular transaction.
+type outputRole int
+
+const (
+	// outputPayment is an output requested by the caller.
+	outputPayment outputRole = iota
+
+	// outputChange returns the value of the inputs that is not spent.
+	outputChange
+)
+
+func (r outputRole) String() string {
+	switch r {
+	case outputPayment:
+		return "payment"
+	case outputChange:
+		return "change"
+	default:
+		return fmt.Sprintf("unknown output role %d", int(r))
+	}
+}
+
+// txOutput describes an output of a transaction built by RegularTransaction.
+type txOutput struct {
+	Index  uint32
+	Role   outputRole
+	Amount dcrutil.Amount
+}
+
+// regularTxResult describes a transaction built by RegularTransaction.
+type regularTxResult struct {
+	Tx      *wire.MsgTx
+	Hash    chainhash.Hash
+	Fee     dcrutil.Amount
+	Outputs []txOutput
+
+	// SignedTx is the serialized signed transaction, it is not set in
+	// dry-run mode.
+	SignedTx []byte
+}
+
+// payments returns the payment outputs in the order they were requested.
+func (r *regularTxResult) payments() []txOutput {
+	var payments []txOutput
+	for _, output := range r.Outputs {
+		if output.Role == outputPayment {
+			payments = append(payments, output)
+		}
+	}
+	return payments
+}
+
+// outPoint returns the outpoint of output.
+func (r *regularTxResult) outPoint(output txOutput) *wire.OutPoint {
+	return wire.NewOutPoint(&r.Hash, output.Index, wire.TxTreeRegular)
+}
+
 type RegularTransaction struct {
-	cfg           *config
-	outputScript  []byte
-	changeScript  []byte
-	outputAmount  dcrutil.Amount
-	utxos         []wallettypes.ListUnspentResult
-	walletService pb.WalletServiceClient
+	cfg          *config
+	outputs      []*wire.TxOut
+	changeScript []byte
+	outputAmount dcrutil.Amount
+	utxos        []*unspentOutput
+	wallet       WalletBackend
+
+	// coinSelector selects the inputs, it defaults to the configured
+	// strategy.
+	coinSelector CoinSelector
+
+	// maxFoldedChange is the largest change amount that is added to the
+	// fee instead of creating a change output.  Dust change is always
+	// added to the fee.
+	maxFoldedChange dcrutil.Amount
+
+	// prepared, if set, is called with the built transaction before it is
+	// signed and published.  The transaction is not published when it
+	// returns an error.  It is not called in dry-run mode.
+	prepared func(result *regularTxResult) error
+
+	// signOnly signs the transaction without publishing it, for
+	// transactions published by someone else.  It does not expire.
+	signOnly bool
+
+	// limits, if set, are checked before the transaction is signed and
+	// record it once it is published.  Transactions that are only signed
+	// are recorded by whoever learns that they were published.
+	limits *spendLimits
+
+	// checkLimits, if set, checks the spending limits with the fee of the
+	// transaction instead of limits.  The caller records the spending.
+	checkLimits func(fee dcrutil.Amount) error
 }
 
-func NewRegularTransaction(cfg *config, outputScript, changeScript []byte, outputAmount dcrutil.Amount, utxos []wallettypes.ListUnspentResult, walletService pb.WalletServiceClient) *RegularTransaction {
+func NewRegularTransaction(cfg *config, outputs []*wire.TxOut, changeScript []byte, utxos []*unspentOutput, wallet WalletBackend) *RegularTransaction {
+	var outputAmount dcrutil.Amount
+	for _, output := range outputs {
+		outputAmount += dcrutil.Amount(output.Value)
+	}
+
 	return &RegularTransaction{
-		cfg:           cfg,
-		outputScript:  outputScript,
-		changeScript:  changeScript,
-		outputAmount:  outputAmount,
-		utxos:         utxos,
-		walletService: walletService,
+		cfg:          cfg,
+		outputs:      outputs,
+		changeScript: changeScript,
+		outputAmount: outputAmount,
+		utxos:        utxos,
+		wallet:       wallet,
+		coinSelector: cfg.coinSelector,
 	}
 }
 
-func (rt *RegularTransaction) broadcastTransaction() (*wire.MsgTx, error) {
+func (rt *RegularTransaction) broadcastTransaction() (*regularTxResult, error) {
 
 	mtx := wire.NewMsgTx()
 
-	txOut := wire.NewTxOut(int64(rt.outputAmount), rt.outputScript)
-	mtx.AddTxOut(txOut)
+	outputs := make([]txOutput, 0, len(rt.outputs)+1)
+	for _, txOut := range rt.outputs {
+		outputs = append(outputs, txOutput{
+			Index:  uint32(len(mtx.TxOut)),
+			Role:   outputPayment,
+			Amount: dcrutil.Amount(txOut.Value),
+		})
+		mtx.AddTxOut(txOut)
+	}
 
 	changeScriptSize := txsizes.P2PKHPkScriptSize
 
-	// init'd with a single script for inital tx fee estimation
-	scriptSizes := []int{txsizes.RedeemP2PKHSigScriptSize}
+	target := &selectionTarget{
+		amount:           rt.outputAmount,
+		outputs:          rt.outputs,
+		changeScriptSize: changeScriptSize,
+		relayFee:         txRelayFeeDCR,
+	}
+	coins, err := rt.coinSelector.SelectCoins(spendableCoins(rt.utxos), target)
+	if err != nil {
+		return nil, err
+	}
 
-	maxSignedSize := txsizes.EstimateSerializeSize(scriptSizes, mtx.TxOut, changeScriptSize)
-	targetFee := txrules.FeeForSerializeSize(txRelayFeeDCR, maxSignedSize)
+	var inputAmount dcrutil.Amount
+	for _, c := range coins {
+		mtx.AddTxIn(wire.NewTxIn(&c.OutPoint, int64(c.Amount), nil))
+		inputAmount += c.Amount
+	}
+	mtx.SerType = wire.TxSerializeFull
+	mtx.Version = generatedTxVersion
 
-	for {
-		inputDetail, err := rt.selectInputsForAmount(rt.outputAmount + targetFee)
+	// Transactions that are only signed are published by someone else at
+	// a height that is not known here.
+	if rt.cfg.TxExpiry > 0 && !rt.signOnly {
+		_, height, err := rt.wallet.BestBlock(context.Background())
 		if err != nil {
 			return nil, err
 		}
+		mtx.Expiry = uint32(height + rt.cfg.TxExpiry + 1)
+	}
 
-		if inputDetail.Amount < rt.outputAmount+targetFee {
-			return nil, errors.E(errors.InsufficientBalance)
-		}
-
-		scriptSizes := make([]int, 0, len(inputDetail.RedeemScriptSizes))
-		scriptSizes = append(scriptSizes, inputDetail.RedeemScriptSizes...)
+	maxSignedSize := target.size(coins, true)
+	maxRequiredFee := target.fee(coins, true)
+	changeAmount := inputAmount - rt.outputAmount - maxRequiredFee
+	if changeAmount > rt.maxFoldedChange && !txrules.IsDustAmount(changeAmount, changeScriptSize, txRelayFeeDCR) {
 
-		maxSignedSize = txsizes.EstimateSerializeSize(scriptSizes, mtx.TxOut, changeScriptSize)
-		maxRequiredFee := txrules.FeeForSerializeSize(txRelayFeeDCR, maxSignedSize)
-		remainingAmount := inputDetail.Amount - rt.outputAmount
-		if remainingAmount < maxRequiredFee {
-			targetFee = maxRequiredFee
-			continue
+		if len(rt.changeScript) > txscript.MaxScriptElementSize {
+			return nil, errors.E(errors.Invalid, "script size exceed maximum bytes "+
+				"pushable to the stack")
 		}
 
-		mtx.TxIn = inputDetail.Inputs
-		mtx.SerType = wire.TxSerializeFull
-		mtx.Version = generatedTxVersion
-
-		changeAmount := inputDetail.Amount - rt.outputAmount - maxRequiredFee
-		if changeAmount != 0 && !txrules.IsDustAmount(changeAmount, changeScriptSize, txRelayFeeDCR) {
+		change := &wire.TxOut{
+			Value:    int64(changeAmount),
+			PkScript: rt.changeScript,
+		}
 
-			if len(rt.changeScript) > txscript.MaxScriptElementSize {
-				return nil, errors.E(errors.Invalid, "script size exceed maximum bytes "+
-					"pushable to the stack")
-			}
+		outputs = append(outputs, txOutput{
+			Index:  uint32(len(mtx.TxOut)),
+			Role:   outputChange,
+			Amount: changeAmount,
+		})
+		mtx.AddTxOut(change)
+	} else {
+		// Without change the remaining value is added to the fee.
+		changeAmount = 0
+		maxSignedSize = target.size(coins, false)
+	}
 
-			change := &wire.TxOut{
-				Value:    int64(changeAmount),
-				PkScript: rt.changeScript,
-			}
+	result := &regularTxResult{
+		Tx:      mtx,
+		Hash:    mtx.TxHash(),
+		Fee:     inputAmount - rt.outputAmount - changeAmount,
+		Outputs: outputs,
+	}
 
-			mtx.AddTxOut(change)
-		}
+	switch {
+	case rt.checkLimits != nil:
+		err = rt.checkLimits(result.Fee)
+	case rt.limits != nil:
+		err = rt.limits.checkTransaction(rt.outputAmount, result.Fee)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-		serializedTx, err := mtx.Bytes()
+	if rt.cfg.DryRun {
+		err := printUnsignedTransaction(mtx, maxSignedSize, rt.cfg.params)
 		if err != nil {
 			return nil, err
 		}
+		return result, nil
+	}
 
-		_, err = signAndPublishTransaction(rt.cfg.WalletPassphrase, serializedTx, rt.walletService)
+	if rt.prepared != nil {
+		err := rt.prepared(result)
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		return mtx, nil
+	serializedTx, err := mtx.Bytes()
+	if err != nil {
+		return nil, err
 	}
 
-}
+	pass, err := rt.cfg.walletPassphrase()
+	if err != nil {
+		return nil, err
+	}
+	result.SignedTx, err = signTransaction(pass, serializedTx, rt.wallet)
+	zeroBytes(pass)
+	if err != nil {
+		return nil, err
+	}
 
-func (rt *RegularTransaction) selectInputsForAmount(targetAmount dcrutil.Amount) (*txauthor.InputDetail, error) {
-
-	var (
-		currentTotal      dcrutil.Amount
-		currentInputs     []*wire.TxIn
-		currentScripts    [][]byte
-		redeemScriptSizes []int
-	)
-
-	unspentOutputs := rt.utxos
-
-	rand.Seed(time.Now().UnixNano())
-	rand.Shuffle(len(unspentOutputs), func(i, j int) {
-		unspentOutputs[i], unspentOutputs[j] = unspentOutputs[j], unspentOutputs[i]
-	})
-
-	for _, unspentOutput := range unspentOutputs {
-		if unspentOutput.Spendable && unspentOutput.Account == rt.cfg.SourceAccountName {
-			unspentOutputAmount, err := dcrutil.NewAmount(unspentOutput.Amount)
-			if err != nil {
-				return nil, err
-			}
-
-			txHash, err := chainhash.NewHashFromStr(unspentOutput.TxID)
-			if err != nil {
-				return nil, err
-			}
-
-			txInOutpoint := wire.NewOutPoint(txHash, unspentOutput.Vout, unspentOutput.Tree)
-			txIn := wire.NewTxIn(txInOutpoint, int64(unspentOutputAmount), nil)
-
-			pkScript, err := hex.DecodeString(unspentOutput.ScriptPubKey)
-			if err != nil {
-				return nil, err
-			}
-
-			scriptClass := txscript.GetScriptClass(0, pkScript)
-			var scriptSize int
-
-			switch scriptClass {
-			case txscript.PubKeyHashTy:
-				scriptSize = txsizes.RedeemP2PKHSigScriptSize
-			case txscript.PubKeyTy:
-				scriptSize = txsizes.RedeemP2PKSigScriptSize
-			case txscript.StakeRevocationTy, txscript.StakeSubChangeTy, txscript.StakeGenTy:
-				scriptClass, err = txscript.GetStakeOutSubclass(pkScript)
-				if err != nil {
-					return nil, errors.Errorf(
-						"failed to extract nested script in stake output: %v",
-						err)
-				}
-
-				// For stake transactions we expect P2PKH and P2SH script class
-				// types only but ignore P2SH script type since it can pay
-				// to any script which the wallet may not recognize.
-				if scriptClass != txscript.PubKeyHashTy {
-					fmt.Printf("unexpected nested script class for credit: %v\n",
-						scriptClass)
-					continue
-				}
-
-				scriptSize = txsizes.RedeemP2PKHSigScriptSize
-			default:
-				fmt.Printf("unexpected script class for credit: %v\n",
-					scriptClass)
-				continue
-			}
-
-			currentTotal += unspentOutputAmount
-			currentInputs = append(currentInputs, txIn)
-			currentScripts = append(currentScripts, pkScript)
-			redeemScriptSizes = append(redeemScriptSizes, scriptSize)
-
-			if currentTotal >= targetAmount {
-				return &txauthor.InputDetail{
-					Amount:            currentTotal,
-					Inputs:            currentInputs,
-					Scripts:           currentScripts,
-					RedeemScriptSizes: redeemScriptSizes,
-				}, nil
-			}
+	if rt.signOnly {
+		return result, nil
+	}
+
+	_, err = publishTransaction(result.SignedTx, rt.wallet)
+	if err != nil {
+		return nil, err
+	}
+
+	if rt.limits != nil {
+		err := rt.limits.recordTransaction(rt.outputAmount, result.Fee)
+		if err != nil {
+			fmt.Printf("Recording the spending of transaction %s failed: %v\n",
+				result.Hash, err)
 		}
 	}
 
-	return nil, errors.E(errors.InsufficientBalance)
+	return result, nil
 }
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/decred/dcrd/dcrutil/v2"
+	"github.com/decred/dcrd/wire"
+)
+
+func TestBroadcastTransaction(t *testing.T) {
+	const amount = 2 * dcrutil.AtomsPerCoin
+
+	tests := []struct {
+		name       string
+		utxos      []dcrutil.Amount
+		passphrase string
+		signErr    error
+		publishErr error
+		signOnly   bool
+		wantErr    bool
+		wantChange bool
+	}{{
+		name:       "change",
+		utxos:      []dcrutil.Amount{5 * dcrutil.AtomsPerCoin},
+		wantChange: true,
+	}, {
+		name:  "dust change added to fee",
+		utxos: []dcrutil.Amount{amount + 5000},
+	}, {
+		name:       "sign only",
+		utxos:      []dcrutil.Amount{5 * dcrutil.AtomsPerCoin},
+		signOnly:   true,
+		wantChange: true,
+	}, {
+		name:    "insufficient funds",
+		utxos:   []dcrutil.Amount{1 * dcrutil.AtomsPerCoin, amount / 2},
+		wantErr: true,
+	}, {
+		name:       "wrong passphrase",
+		utxos:      []dcrutil.Amount{5 * dcrutil.AtomsPerCoin},
+		passphrase: "wrong",
+		wantErr:    true,
+	}, {
+		name:    "sign error",
+		utxos:   []dcrutil.Amount{5 * dcrutil.AtomsPerCoin},
+		signErr: errors.New("sign failed"),
+		wantErr: true,
+	}, {
+		name:       "publish error",
+		utxos:      []dcrutil.Amount{5 * dcrutil.AtomsPerCoin},
+		publishErr: errors.New("publish failed"),
+		wantErr:    true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, cleanup := tempDir(t)
+			defer cleanup()
+
+			cfg := newTestConfig(dir)
+			cfg.TxExpiry = 10
+			if test.passphrase != "" {
+				cfg.walletPass = []byte(test.passphrase)
+			}
+			w := newTestWallet()
+			w.height = 100
+			for _, utxo := range test.utxos {
+				if err := w.addUnspentOutput(0, utxo); err != nil {
+					t.Fatal(err)
+				}
+			}
+			newTestTicketBuyer(t, cfg, w)
+			w.signErr = test.signErr
+			w.publishErr = test.publishErr
+
+			ctx := context.Background()
+			destAddr, err := w.NextAddress(ctx, 3, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			destScript, _, err := addressScript(destAddr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, changeScript, err := generateAddress(true, 1, w)
+			if err != nil {
+				t.Fatal(err)
+			}
+			utxos, err := w.UnspentOutputs(ctx, 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			outputs := []*wire.TxOut{wire.NewTxOut(amount, destScript)}
+			rt := NewRegularTransaction(cfg, outputs, changeScript, utxos, w)
+			rt.signOnly = test.signOnly
+			result, err := rt.broadcastTransaction()
+			published := w.publishedTransactions()
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				if len(published) != 0 {
+					t.Fatalf("%d transaction(s) published after an error", len(published))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.signOnly {
+				if len(published) != 0 {
+					t.Fatal("sign only transaction was published")
+				}
+				if result.SignedTx == nil {
+					t.Fatal("sign only transaction was not signed")
+				}
+			} else if len(published) != 1 || published[0].TxHash() != result.Hash {
+				t.Fatalf("published %d transaction(s), want %s", len(published), result.Hash)
+			}
+
+			checkTxOutputs(t, result, outputs, changeScript, test.wantChange)
+
+			// The transaction can be mined in the next --txexpiry
+			// blocks, transactions published by someone else do not
+			// expire.
+			wantExpiry := uint32(100 + cfg.TxExpiry + 1)
+			if test.signOnly {
+				wantExpiry = wire.NoExpiryValue
+			}
+			if result.Tx.Expiry != wantExpiry {
+				t.Errorf("expiry %d, want %d", result.Tx.Expiry, wantExpiry)
+			}
+		})
+	}
+}
+
+// checkTxOutputs checks that the outputs of result are the requested payments
+// in order followed by the change output when one is wanted, and that the fee
+// is the difference of inputs and outputs.
+func checkTxOutputs(t *testing.T, result *regularTxResult, payments []*wire.TxOut, changeScript []byte, wantChange bool) {
+	t.Helper()
+
+	wantOutputs := len(payments)
+	if wantChange {
+		wantOutputs++
+	}
+	if len(result.Outputs) != wantOutputs || len(result.Tx.TxOut) != wantOutputs {
+		t.Fatalf("got %d outputs and %d tx outputs, want %d", len(result.Outputs),
+			len(result.Tx.TxOut), wantOutputs)
+	}
+
+	for i, output := range result.Outputs {
+		txOut := result.Tx.TxOut[output.Index]
+		if output.Index != uint32(i) {
+			t.Errorf("output %d has index %d", i, output.Index)
+		}
+		if output.Amount != dcrutil.Amount(txOut.Value) {
+			t.Errorf("output %d amount %s, tx output value %d", i, output.Amount, txOut.Value)
+		}
+
+		wantRole := outputPayment
+		if i == len(payments) {
+			wantRole = outputChange
+		}
+		if output.Role != wantRole {
+			t.Errorf("output %d role %s, want %s", i, output.Role, wantRole)
+		}
+		switch wantRole {
+		case outputPayment:
+			if !bytes.Equal(txOut.PkScript, payments[i].PkScript) {
+				t.Errorf("payment %d pays to the wrong script", i)
+			}
+		case outputChange:
+			if !bytes.Equal(txOut.PkScript, changeScript) {
+				t.Errorf("change output pays to the wrong script")
+			}
+		}
+	}
+	if got := len(result.payments()); got != len(payments) {
+		t.Errorf("payments() returned %d outputs, want %d", got, len(payments))
+	}
+
+	var in, out dcrutil.Amount
+	for _, txIn := range result.Tx.TxIn {
+		in += dcrutil.Amount(txIn.ValueIn)
+	}
+	for _, txOut := range result.Tx.TxOut {
+		out += dcrutil.Amount(txOut.Value)
+	}
+	if result.Fee != in-out || result.Fee <= 0 {
+		t.Errorf("fee %s, inputs %s, outputs %s", result.Fee, in, out)
+	}
+}
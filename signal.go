@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// interruptSignals defines the signals that trigger a clean shutdown.
+var interruptSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// shutdownListener returns a context that is canceled once one of the
+// interrupt signals is received.
+func shutdownListener() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		interruptChannel := make(chan os.Signal, 1)
+		signal.Notify(interruptChannel, interruptSignals...)
+
+		sig := <-interruptChannel
+		fmt.Printf("Received signal (%s). Shutting down...\n", sig)
+		signal.Stop(interruptChannel)
+		cancel()
+	}()
+
+	return ctx
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/decred/dcrd/dcrutil/v2"
+	"github.com/decred/dcrd/txscript/v2"
+	"github.com/decred/dcrd/wire"
+	"github.com/decred/dcrwallet/wallet/v3/txrules"
+)
+
+// resolvePoolVotingAddress returns the P2SH voting address of a legacy
+// stakepool from its multisig voting script, its address or both, in which
+// case they must match.
+func resolvePoolVotingAddress(scriptHex, address string, params dcrutil.AddressParams) (dcrutil.Address, error) {
+	var scriptAddr, addr dcrutil.Address
+	if scriptHex != "" {
+		script, err := hex.DecodeString(scriptHex)
+		if err != nil {
+			return nil, fmt.Errorf("poolvotingscript must be hex encoded: %v", err)
+		}
+		scriptAddr, err = dcrutil.NewAddressScriptHash(script, params)
+		if err != nil {
+			return nil, fmt.Errorf("poolvotingscript error: %v", err)
+		}
+	}
+
+	if address != "" {
+		var err error
+		addr, err = dcrutil.DecodeAddress(address, params)
+		if err != nil {
+			return nil, fmt.Errorf("decode poolvotingaddress error: %v", err)
+		}
+		if _, ok := addr.(*dcrutil.AddressScriptHash); !ok {
+			return nil, fmt.Errorf("poolvotingaddress must be a P2SH address")
+		}
+	}
+
+	switch {
+	case scriptAddr != nil && addr != nil:
+		if scriptAddr.Address() != addr.Address() {
+			return nil, fmt.Errorf("poolvotingaddress %s is not the address of "+
+				"poolvotingscript (%s)", addr.Address(), scriptAddr.Address())
+		}
+		return addr, nil
+	case scriptAddr != nil:
+		return scriptAddr, nil
+	case addr != nil:
+		return addr, nil
+	default:
+		return nil, fmt.Errorf("--poolmode requires --poolvotingaddress or --poolvotingscript")
+	}
+}
+
+// poolFee returns the stakepool fee of a ticket with price ticketPrice and fee
+// ticketFee mined in the block after the wallet's main chain tip.
+func (tb *TicketBuyer) poolFee(ticketPrice, ticketFee dcrutil.Amount) (dcrutil.Amount, error) {
+	_, height, err := tb.wallet.BestBlock(context.Background())
+	if err != nil {
+		return 0, err
+	}
+
+	return txrules.StakePoolTicketFee(ticketPrice, ticketFee, height+1,
+		tb.cfg.PoolFees, tb.netParams), nil
+}
+
+// addPoolFeeCommitment adds the commitment of poolFeeAmount to the stakepool
+// fee address and its zero value change output to the ticket mtx.
+func (tb *TicketBuyer) addPoolFeeCommitment(mtx *wire.MsgTx, poolFeeAmount dcrutil.Amount) error {
+	commitmentScript, err := txscript.GenerateSStxAddrPush(tb.cfg.poolFeeAddress,
+		poolFeeAmount, tb.cfg.ticketFeeLimits)
+	if err != nil {
+		return err
+	}
+	mtx.AddTxOut(wire.NewTxOut(0, commitmentScript))
+
+	changeScript, err := txscript.PayToSStxChange(tb.cfg.poolFeeAddress)
+	if err != nil {
+		return err
+	}
+	mtx.AddTxOut(wire.NewTxOut(0, changeScript))
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/decred/dcrd/chaincfg/v2"
+	"github.com/decred/dcrd/dcrutil/v2"
+)
+
+// stakeDiffEstimate is the estimated ticket price of the next stake
+// difficulty window.
+type stakeDiffEstimate struct {
+	Min      dcrutil.Amount
+	Expected dcrutil.Amount
+	Max      dcrutil.Amount
+}
+
+// stakeWindow is the position of the next block in its stake difficulty
+// window.  The ticket price only changes with the first block of a window, a
+// ticket that is not mined before the window ends is rejected.
+type stakeWindow struct {
+	// size is the number of blocks of a window.
+	size int64
+
+	// height is the height of the next block.
+	height int64
+
+	// remaining is the number of blocks left in the window, including the
+	// next block.
+	remaining int64
+}
+
+func newStakeWindow(tipHeight int32, params *chaincfg.Params) *stakeWindow {
+	height := int64(tipHeight) + 1
+	size := params.StakeDiffWindowSize
+	return &stakeWindow{
+		size:      size,
+		height:    height,
+		remaining: size - height%size,
+	}
+}
+
+// index returns the number of the window.
+func (w *stakeWindow) index() int64 {
+	return w.height / w.size
+}
+
+// position returns the 1-based position of the next block in the window.
+func (w *stakeWindow) position() int64 {
+	return w.size - w.remaining + 1
+}
+
+// nextWindowHeight returns the height of the first block of the next window.
+func (w *stakeWindow) nextWindowHeight() int64 {
+	return w.height + w.remaining
+}
+
+func (w *stakeWindow) String() string {
+	return fmt.Sprintf("block %d of %d in the stake difficulty window, "+
+		"next window starts at height %d", w.position(), w.size,
+		w.nextWindowHeight())
+}
+
+// ticketExpiry returns the expiry height of a ticket mined in window.  Without
+// --ticketexpiry a ticket expires with the window, since it is rejected at any
+// later height anyway.
+func (tb *TicketBuyer) ticketExpiry(window *stakeWindow) uint32 {
+	if tb.cfg.TicketExpiry > 0 {
+		return uint32(window.height + int64(tb.cfg.TicketExpiry))
+	}
+	return uint32(window.nextWindowHeight())
+}
+
+// currentStakeWindow returns the stake difficulty window of the next block.
+func (tb *TicketBuyer) currentStakeWindow() (*stakeWindow, error) {
+	_, height, err := tb.wallet.BestBlock(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return newStakeWindow(height, tb.netParams), nil
+}
+
+// windowClosing returns whether too few blocks are left in the window to
+// purchase a ticket at the current price.  A purchase needs the configured
+// window margin in addition to the blocks it waits for funding confirmations.
+func (tb *TicketBuyer) windowClosing(window *stakeWindow) bool {
+	required := int64(tb.cfg.WindowMargin)
+	if !tb.cfg.DryRun {
+		required += int64(tb.cfg.FundingConfs)
+	}
+	return window.remaining <= required
+}
+
+// printStakeInfo prints the current ticket price, the position in the stake
+// difficulty window and the estimated price of the next window.
+func printStakeInfo(wallet WalletBackend, params *chaincfg.Params) error {
+	ctx := context.Background()
+
+	_, height, err := wallet.BestBlock(ctx)
+	if err != nil {
+		return err
+	}
+	ticketPrice, err := wallet.TicketPrice(ctx)
+	if err != nil {
+		return err
+	}
+	window := newStakeWindow(height, params)
+
+	fmt.Printf("Best block height: %d\n", height)
+	fmt.Printf("Ticket Price: %s\n", ticketPrice)
+	fmt.Printf("Stake window: block %d of %d, %d block(s) remaining\n",
+		window.position(), window.size, window.remaining)
+	fmt.Printf("Next window starts at height: %d\n", window.nextWindowHeight())
+
+	// The estimate is not available from every wallet, the window
+	// position is still useful without it.
+	estimate, err := wallet.EstimateStakeDiff(ctx)
+	if err != nil {
+		fmt.Printf("Next window price estimate unavailable: %v\n", err)
+		return nil
+	}
+	fmt.Printf("Next window price estimate: min %s, expected %s, max %s\n",
+		estimate.Min, estimate.Expected, estimate.Max)
+	return nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/decred/dcrd/dcrutil/v2"
+)
+
+const (
+	strategyPriceCeiling    = "priceceiling"
+	strategyMovingAverage   = "movingaverage"
+	strategyMaintainBalance = "maintainbalance"
+	strategyPerWindow       = "perwindow"
+
+	defaultWindowPricesFilename    = "windowprices.json"
+	defaultWindowPurchasesFilename = "windowpurchases.json"
+)
+
+// blockState describes the next block to a Strategy.
+type blockState struct {
+	window      *stakeWindow
+	ticketPrice dcrutil.Amount
+
+	// affordable is the number of tickets the spendable balance above the
+	// balance to maintain pays for.
+	affordable int
+}
+
+// windowIndex returns the number of the stake difficulty window of the next
+// block.
+func (s *blockState) windowIndex() int64 {
+	return s.window.index()
+}
+
+// Strategy decides how many tickets the daemon buys for every attached block.
+// The result is still capped by the affordable tickets and the maximum per
+// block.
+type Strategy interface {
+	// TicketsToBuy returns the number of tickets to buy for the next
+	// block.
+	TicketsToBuy(state *blockState) (int, error)
+
+	// Purchased records that numTickets tickets were purchased for the
+	// next block.
+	Purchased(state *blockState, numTickets int) error
+}
+
+// newStrategy returns the buying strategy configured with --strategy.
+func newStrategy(cfg *config) (Strategy, error) {
+	switch cfg.Strategy {
+	case strategyPriceCeiling:
+		return &priceCeilingStrategy{maxPrice: cfg.maxPrice}, nil
+	case strategyMaintainBalance:
+		return maintainBalanceStrategy{}, nil
+	case strategyMovingAverage:
+		return newMovingAverageStrategy(cfg.AverageWindows,
+			filepath.Join(cfg.dataDir, defaultWindowPricesFilename))
+	case strategyPerWindow:
+		return newPerWindowStrategy(cfg.TicketsPerWindow,
+			filepath.Join(cfg.dataDir, defaultWindowPurchasesFilename))
+	}
+
+	names := []string{strategyPriceCeiling, strategyMovingAverage,
+		strategyMaintainBalance, strategyPerWindow}
+	return nil, fmt.Errorf("unknown buying strategy %q, must be one of %s",
+		cfg.Strategy, strings.Join(names, ", "))
+}
+
+// maintainBalanceStrategy spends everything above the balance to maintain.
+type maintainBalanceStrategy struct{}
+
+func (maintainBalanceStrategy) TicketsToBuy(state *blockState) (int, error) {
+	return state.affordable, nil
+}
+
+func (maintainBalanceStrategy) Purchased(state *blockState, numTickets int) error {
+	return nil
+}
+
+// priceCeilingStrategy spends everything above the balance to maintain while
+// the ticket price is not above maxPrice.  A zero maxPrice disables the
+// ceiling.
+type priceCeilingStrategy struct {
+	maxPrice dcrutil.Amount
+}
+
+func (s *priceCeilingStrategy) TicketsToBuy(state *blockState) (int, error) {
+	if s.maxPrice > 0 && state.ticketPrice > s.maxPrice {
+		fmt.Printf("Ticket price is above the maximum price of %s\n", s.maxPrice)
+		return 0, nil
+	}
+	return state.affordable, nil
+}
+
+func (s *priceCeilingStrategy) Purchased(state *blockState, numTickets int) error {
+	return nil
+}
+
+// windowPrice is the ticket price of a stake difficulty window.
+type windowPrice struct {
+	Window int64          `json:"window"`
+	Price  dcrutil.Amount `json:"price"`
+}
+
+// movingAverageStrategy spends everything above the balance to maintain while
+// the ticket price is below the average price of the previous windows.  The
+// price of every window seen is kept on disk, so the average survives
+// restarts.  Nothing is bought before the price of a previous window is known.
+type movingAverageStrategy struct {
+	windows int
+	path    string
+	prices  []windowPrice
+}
+
+func newMovingAverageStrategy(windows int, path string) (*movingAverageStrategy, error) {
+	s := &movingAverageStrategy{
+		windows: windows,
+		path:    path,
+	}
+	err := readJSONFile(path, &s.prices)
+	if err != nil {
+		return nil, fmt.Errorf("invalid window prices %s: %v", path, err)
+	}
+	return s, nil
+}
+
+// observe records the ticket price of the window of state.
+func (s *movingAverageStrategy) observe(state *blockState) error {
+	window := state.windowIndex()
+	if n := len(s.prices); n != 0 && s.prices[n-1].Window == window {
+		return nil
+	}
+
+	s.prices = append(s.prices, windowPrice{Window: window, Price: state.ticketPrice})
+	if len(s.prices) > s.windows+1 {
+		s.prices = s.prices[len(s.prices)-s.windows-1:]
+	}
+	return writeJSONFile(s.path, s.prices)
+}
+
+// average returns the average price of up to the configured number of windows
+// before window and the number of windows averaged.
+func (s *movingAverageStrategy) average(window int64) (dcrutil.Amount, int) {
+	var sum dcrutil.Amount
+	var n int
+	for i := len(s.prices) - 1; i >= 0 && n < s.windows; i-- {
+		if s.prices[i].Window >= window {
+			continue
+		}
+		sum += s.prices[i].Price
+		n++
+	}
+	if n == 0 {
+		return 0, 0
+	}
+	return sum / dcrutil.Amount(n), n
+}
+
+func (s *movingAverageStrategy) TicketsToBuy(state *blockState) (int, error) {
+	err := s.observe(state)
+	if err != nil {
+		return 0, err
+	}
+
+	average, n := s.average(state.windowIndex())
+	if n == 0 {
+		fmt.Println("No ticket price of a previous window is known yet")
+		return 0, nil
+	}
+
+	fmt.Printf("Average ticket price of the last %d window(s): %s\n", n, average)
+	if state.ticketPrice >= average {
+		fmt.Println("Ticket price is not below the average price")
+		return 0, nil
+	}
+	return state.affordable, nil
+}
+
+func (s *movingAverageStrategy) Purchased(state *blockState, numTickets int) error {
+	return nil
+}
+
+// windowPurchases is the number of tickets purchased in a stake difficulty
+// window.
+type windowPurchases struct {
+	Window  int64 `json:"window"`
+	Tickets int   `json:"tickets"`
+}
+
+// perWindowStrategy buys a fixed number of tickets in every stake difficulty
+// window.  The tickets purchased in the current window are kept on disk, so
+// a restart does not buy them again.
+type perWindowStrategy struct {
+	perWindow int
+	path      string
+	purchases windowPurchases
+}
+
+func newPerWindowStrategy(perWindow int, path string) (*perWindowStrategy, error) {
+	s := &perWindowStrategy{
+		perWindow: perWindow,
+		path:      path,
+	}
+	err := readJSONFile(path, &s.purchases)
+	if err != nil {
+		return nil, fmt.Errorf("invalid window purchases %s: %v", path, err)
+	}
+	return s, nil
+}
+
+// purchased returns the number of tickets purchased in the window of state.
+func (s *perWindowStrategy) purchased(state *blockState) int {
+	if s.purchases.Window != state.windowIndex() {
+		return 0
+	}
+	return s.purchases.Tickets
+}
+
+func (s *perWindowStrategy) TicketsToBuy(state *blockState) (int, error) {
+	remaining := s.perWindow - s.purchased(state)
+	fmt.Printf("%d of %d ticket(s) left to purchase in this window\n",
+		remaining, s.perWindow)
+	return remaining, nil
+}
+
+func (s *perWindowStrategy) Purchased(state *blockState, numTickets int) error {
+	s.purchases = windowPurchases{
+		Window:  state.windowIndex(),
+		Tickets: s.purchased(state) + numTickets,
+	}
+	return writeJSONFile(s.path, &s.purchases)
+}
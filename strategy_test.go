@@ -0,0 +1,199 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/decred/dcrd/dcrutil/v2"
+)
+
+const testWindowSize = 144
+
+// testBlockState returns the state of the next block at height.
+func testBlockState(height int64, ticketPrice dcrutil.Amount, affordable int) *blockState {
+	return &blockState{
+		window: &stakeWindow{
+			size:      testWindowSize,
+			height:    height,
+			remaining: testWindowSize - height%testWindowSize,
+		},
+		ticketPrice: ticketPrice,
+		affordable:  affordable,
+	}
+}
+
+func TestPriceCeilingStrategy(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxPrice dcrutil.Amount
+		price    dcrutil.Amount
+		want     int
+	}{{
+		name:  "no ceiling",
+		price: 1000,
+		want:  5,
+	}, {
+		name:     "below ceiling",
+		maxPrice: 100,
+		price:    99,
+		want:     5,
+	}, {
+		name:     "at ceiling",
+		maxPrice: 100,
+		price:    100,
+		want:     5,
+	}, {
+		name:     "above ceiling",
+		maxPrice: 100,
+		price:    101,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &priceCeilingStrategy{maxPrice: test.maxPrice}
+			state := testBlockState(10, test.price, 5)
+			n, err := s.TicketsToBuy(state)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != test.want {
+				t.Fatalf("buys %d tickets, want %d", n, test.want)
+			}
+			err = s.Purchased(state, n)
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestMaintainBalanceStrategy(t *testing.T) {
+	var s maintainBalanceStrategy
+	for _, affordable := range []int{0, 1, 7} {
+		state := testBlockState(10, 100, affordable)
+		n, err := s.TicketsToBuy(state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != affordable {
+			t.Fatalf("buys %d tickets of %d affordable", n, affordable)
+		}
+		err = s.Purchased(state, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// strategyStep is a block considered by a strategy.
+type strategyStep struct {
+	height int64
+	price  dcrutil.Amount
+
+	// restart reopens the strategy from its file before the step.
+	restart bool
+
+	// want is the number of tickets to buy of 5 affordable ones.
+	want int
+
+	// purchased is the number of tickets recorded as purchased.
+	purchased int
+}
+
+func TestMovingAverageStrategy(t *testing.T) {
+	const windows = 2
+
+	steps := []strategyStep{
+		// Nothing is bought without the price of a previous window.
+		{height: 10, price: 100},
+		{height: 20, price: 100},
+		// The price of window 0 is the average.
+		{height: 150, price: 90, want: 5},
+		{height: 160, price: 100},
+		// The average survives a restart, window 1 is averaged too.
+		{height: 300, price: 110, restart: true},
+		{height: 310, price: 94, want: 5},
+		// Only the last two windows are averaged after the rollover:
+		// (90 + 110) / 2.
+		{height: 450, price: 100},
+		{height: 460, price: 99, want: 5, restart: true},
+	}
+
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, defaultWindowPricesFilename)
+
+	s, err := newMovingAverageStrategy(windows, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, step := range steps {
+		if step.restart {
+			s, err = newMovingAverageStrategy(windows, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		state := testBlockState(step.height, step.price, 5)
+		n, err := s.TicketsToBuy(state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != step.want {
+			t.Fatalf("step %d: buys %d tickets, want %d", i, n, step.want)
+		}
+		if len(s.prices) > windows+1 {
+			t.Fatalf("step %d: %d window prices kept", i, len(s.prices))
+		}
+	}
+}
+
+func TestPerWindowStrategy(t *testing.T) {
+	const perWindow = 2
+
+	steps := []strategyStep{
+		{height: 10, want: 2, purchased: 1},
+		// The purchase survives a restart.  When no ticket is published
+		// nothing is recorded and the ticket is still left to buy.
+		{height: 20, want: 1, restart: true},
+		{height: 21, want: 1, purchased: 1},
+		{height: 22},
+		// The count resets with the next window.
+		{height: 150, want: 2, purchased: 2},
+		{height: 151, restart: true},
+		{height: 300, want: 2, restart: true},
+	}
+
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, defaultWindowPurchasesFilename)
+
+	s, err := newPerWindowStrategy(perWindow, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, step := range steps {
+		if step.restart {
+			s, err = newPerWindowStrategy(perWindow, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		state := testBlockState(step.height, 100, 5)
+		n, err := s.TicketsToBuy(state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != step.want {
+			t.Fatalf("step %d: buys %d tickets, want %d", i, n, step.want)
+		}
+		if step.purchased != 0 {
+			err = s.Purchased(state, step.purchased)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
@@ -2,19 +2,15 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 
 	"github.com/decred/dcrd/blockchain/stake/v2"
-	"github.com/decred/dcrd/dcrjson/v3"
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/chaincfg/v2"
 	"github.com/decred/dcrd/dcrutil/v2"
 	"github.com/decred/dcrd/txscript/v2"
 	"github.com/decred/dcrd/wire"
-	"github.com/decred/dcrwallet/errors/v2"
-	wallettypes "github.com/decred/dcrwallet/rpc/jsonrpc/types"
-	pb "github.com/decred/dcrwallet/rpc/walletrpc"
 	"github.com/decred/dcrwallet/wallet/v3/txrules"
-	"google.golang.org/grpc"
 )
 
 const (
@@ -28,21 +24,49 @@ var (
 )
 
 type TicketBuyer struct {
-	conn          *grpc.ClientConn
-	walletService pb.WalletServiceClient
+	wallet WalletBackend
+
+	// fundingSelector selects the inputs of funding transactions.  It
+	// looks for inputs that need no change output before falling back to
+	// the configured strategy.
+	fundingSelector *exactMatchSelector
+
+	// journal records the progress of purchases so they can be resumed
+	// after a crash.
+	journal *purchaseJournal
+
+	// vsp pays the fees of tickets voted by a VSP, it is nil unless VSP
+	// mode is enabled.
+	vsp *vspManager
+
+	// votingXPub derives voting addresses from the extended public key of
+	// a separate voting wallet, it is nil unless --votingxpub is set.
+	votingXPub *xpubVotingAddresses
+
+	// strategy decides how many tickets the daemon buys, it is nil unless
+	// --daemon is set.
+	strategy Strategy
+
+	// limits are the spending limits enforced before signing.
+	limits *spendLimits
 
 	cfg *config
 
-	netParams dcrutil.AddressParams
+	netParams *chaincfg.Params
 }
 
-func NewTicketBuyer(cfg *config, conn *grpc.ClientConn, netParams dcrutil.AddressParams) *TicketBuyer {
+func NewTicketBuyer(cfg *config, wallet WalletBackend, journal *purchaseJournal, vsp *vspManager, votingXPub *xpubVotingAddresses, strategy Strategy, limits *spendLimits, netParams *chaincfg.Params) *TicketBuyer {
 
 	return &TicketBuyer{
-		cfg:           cfg,
-		conn:          conn,
-		walletService: pb.NewWalletServiceClient(conn),
-		netParams:     netParams,
+		cfg:             cfg,
+		wallet:          wallet,
+		fundingSelector: newExactMatchSelector(cfg.fundingTolerance, cfg.MaxFeePercent, cfg.coinSelector),
+		journal:         journal,
+		vsp:             vsp,
+		votingXPub:      votingXPub,
+		strategy:        strategy,
+		limits:          limits,
+		netParams:       netParams,
 	}
 }
 
@@ -56,182 +80,392 @@ func (tb *TicketBuyer) updateFees() error {
 	return tb.updateTransactionRelayFee()
 }
 
-func (tb *TicketBuyer) printBalance() error {
+func (tb *TicketBuyer) updateTicketRelayFee() error {
 	ctx := context.Background()
-
-	balanceRequest := &pb.BalanceRequest{
-		AccountNumber:         tb.cfg.SourceAccount,
-		RequiredConfirmations: requiredConfirmations,
-	}
-	balanceResponse, err := tb.walletService.Balance(ctx, balanceRequest)
+	relayFee, err := tb.wallet.TicketRelayFee(ctx)
 	if err != nil {
 		return err
 	}
-	spendableBal := dcrutil.Amount(balanceResponse.Spendable)
-	fmt.Printf("Mixed account spendable balance: %s\n", spendableBal)
+
+	ticketFeeRelayDCR = relayFee
 	return nil
 }
 
-func (tb *TicketBuyer) updateTicketRelayFee() error {
-	ticketFeeCmd := wallettypes.NewGetTicketFeeCmd()
-	marshalledJSON, err := dcrjson.MarshalCmd(rpcVersion, 1, ticketFeeCmd)
+func (tb *TicketBuyer) updateTransactionRelayFee() error {
+	return updateTxRelayFee(tb.wallet)
+}
+
+// updateTxRelayFee sets the relay fee of regular transactions to the one of
+// wallet.
+func updateTxRelayFee(wallet WalletBackend) error {
+	ctx := context.Background()
+	relayFee, err := wallet.TxRelayFee(ctx)
 	if err != nil {
 		return err
 	}
 
-	resp, err := sendPostRequest(tb.cfg.RPCServer, tb.cfg.RPCUser, tb.cfg.RPCPass, marshalledJSON)
-	if err != nil {
-		return err
+	txRelayFeeDCR = relayFee
+	return nil
+}
+
+func (tb *TicketBuyer) getTicketPrice() (dcrutil.Amount, error) {
+	ctx := context.Background()
+	return tb.wallet.TicketPrice(ctx)
+}
+
+// ticketFunding is the funding of a single ticket by outputs of a funding
+// transaction.
+type ticketFunding struct {
+	outPoint *wire.OutPoint
+	amount   dcrutil.Amount
+
+	// poolFeeOutPoint is the output paying the stakepool fee in pool mode
+	// and nil otherwise.
+	poolFeeOutPoint *wire.OutPoint
+	poolFeeAmount   dcrutil.Amount
+}
+
+// total returns the total value funding the ticket.
+func (f *ticketFunding) total() dcrutil.Amount {
+	return f.amount + f.poolFeeAmount
+}
+
+// in returns whether every funding output is in outPoints.
+func (f *ticketFunding) in(outPoints map[wire.OutPoint]bool) bool {
+	if f.poolFeeOutPoint != nil && !outPoints[*f.poolFeeOutPoint] {
+		return false
 	}
+	return outPoints[*f.outPoint]
+}
 
-	var relayFee float64
-	err = json.Unmarshal(resp.Result, &relayFee)
-	if err != nil {
-		return err
+// ticketFundings groups the payment outputs of the funding transaction into the
+// funding of every ticket.  In pool mode every ticket is funded by a pool fee
+// output followed by an output paying the rest of the ticket cost.
+func (tb *TicketBuyer) ticketFundings(funding *regularTxResult) ([]*ticketFunding, error) {
+	payments := funding.payments()
+	outputsPerTicket := 1
+	if tb.cfg.PoolMode {
+		outputsPerTicket = 2
+	}
+	if len(payments)%outputsPerTicket != 0 {
+		return nil, fmt.Errorf("funding transaction has %d ticket outputs, "+
+			"expected a multiple of %d", len(payments), outputsPerTicket)
+	}
+
+	fundings := make([]*ticketFunding, 0, len(payments)/outputsPerTicket)
+	for len(payments) != 0 {
+		f := new(ticketFunding)
+		if tb.cfg.PoolMode {
+			f.poolFeeOutPoint = funding.outPoint(payments[0])
+			f.poolFeeAmount = payments[0].Amount
+			payments = payments[1:]
+		}
+		f.outPoint = funding.outPoint(payments[0])
+		f.amount = payments[0].Amount
+		payments = payments[1:]
+		fundings = append(fundings, f)
 	}
+	return fundings, nil
+}
 
-	ticketFeeRelayDCR, err = dcrutil.NewAmount(relayFee)
-	if err != nil {
-		return err
+// ticketCosts returns the fee of a ticket and, in pool mode, the part of the
+// ticket cost paid to the stakepool.
+func (tb *TicketBuyer) ticketCosts(ticketPrice dcrutil.Amount) (ticketFee, poolFee dcrutil.Amount, err error) {
+	ticketFee = txrules.FeeForSerializeSize(ticketFeeRelayDCR,
+		estimateTicketSize(tb.cfg.PoolMode))
+	if tb.cfg.PoolMode {
+		poolFee, err = tb.poolFee(ticketPrice, ticketFee)
 	}
+	return ticketFee, poolFee, err
+}
 
-	return nil
+// ticketResult describes the outcome of purchasing a single ticket from a
+// funding transaction output.
+type ticketResult struct {
+	fundingOutputIndex uint32
+	hash               *chainhash.Hash
+	err                error
 }
 
-func (tb *TicketBuyer) updateTransactionRelayFee() error {
-	walletFeeCmd := wallettypes.NewGetWalletFeeCmd()
-	marshalledJSON, err := dcrjson.MarshalCmd(rpcVersion, 1, walletFeeCmd)
+// purchaseTickets funds numTickets tickets with a single split transaction
+// and then builds, signs and publishes one ticket per ticket-sized output.
+// Tickets are purchased independently, a failure to publish one ticket does
+// not prevent the remaining tickets from being purchased.  It returns the
+// number of tickets published, or funded when the tickets are completed from
+// the journal once the funding transaction is confirmed.
+func (tb *TicketBuyer) purchaseTickets(numTickets int) (int, error) {
+
+	// A ticket that is not mined before the stake difficulty window ends
+	// is rejected and would strand its funding output until reclaimed.
+	window, err := tb.currentStakeWindow()
 	if err != nil {
-		return err
+		return 0, err
 	}
-
-	resp, err := sendPostRequest(tb.cfg.RPCServer, tb.cfg.RPCUser, tb.cfg.RPCPass, marshalledJSON)
-	if err != nil {
-		return err
+	if tb.windowClosing(window) {
+		return 0, fmt.Errorf("next block is %s, too close to the end of the window "+
+			"to purchase tickets at the current price", window)
 	}
 
-	var relayFee float64
-	err = json.Unmarshal(resp.Result, &relayFee)
+	tb.printUnspentOutputs()
+	ticketPrice, err := tb.getTicketPrice()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	txRelayFeeDCR, err = dcrutil.NewAmount(relayFee)
+	ticketFee, poolFee, err := tb.ticketCosts(ticketPrice)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	fmt.Printf("Ticket Price: %s, Ticket Fee: %s\n", ticketPrice, ticketFee)
+	fmt.Printf("Commitment fee limits: %s\n", describeFeeLimits(tb.cfg.ticketFeeLimits))
+	totalTicketCost := ticketPrice + ticketFee
 
-	return nil
-}
-
-func (tb *TicketBuyer) listenForBlockNotifications() error {
-	ctx := context.Background()
-	notifiationClient, err := tb.walletService.TransactionNotifications(ctx, &pb.TransactionNotificationsRequest{})
+	// Funding outputs are only created for tickets the spending limits
+	// allow.  The check is repeated with the fee of the funding
+	// transaction once it is built.
+	err = tb.limits.checkTickets(numTickets, ticketPrice, ticketFee, 0, window.index())
 	if err != nil {
-		return err
+		return 0, err
+	}
+	checkLimits := func(fundingFee dcrutil.Amount) error {
+		return tb.limits.checkTickets(numTickets, ticketPrice, ticketFee,
+			fundingFee, window.index())
 	}
 
-	fmt.Println("Listening for block notifcations")
-	for {
-		notificationResponse, err := notifiationClient.Recv()
+	// In pool mode the pool fee is paid by a separate funding output and
+	// commitment, the remaining cost is committed to the user.
+	outputAmounts := make([]dcrutil.Amount, 0, 2*numTickets)
+	for i := 0; i < numTickets; i++ {
+		if tb.cfg.PoolMode {
+			outputAmounts = append(outputAmounts, poolFee)
+		}
+		outputAmounts = append(outputAmounts, totalTicketCost-poolFee)
+	}
+	if tb.cfg.PoolMode {
+		fmt.Printf("Pool Fee: %s\n", poolFee)
+	}
+
+	// Tickets spending a funding transaction that must be confirmed first
+	// are completed from the journal once it is.  Without the daemon the
+	// confirmations are awaited here, subscribing to notifications before
+	// publishing so the block mining the transaction is not missed.
+	waitForFunding := tb.cfg.FundingConfs > 0 && !tb.cfg.DryRun
+	var notifications notificationStream
+	if waitForFunding && !tb.cfg.Daemon {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		notifications, err = tb.wallet.TransactionNotifications(ctx)
 		if err != nil {
-			if err == context.Canceled {
-				break
-			}
+			return 0, err
+		}
+	}
 
+	// The purchase is only recorded once the funding transaction is about
+	// to be published, dry runs are never recorded.
+	var purchase *journalPurchase
+	recordPurchase := func(result *regularTxResult) error {
+		fundings, err := tb.ticketFundings(result)
+		if err != nil {
 			return err
 		}
+		purchase, err = tb.journal.addPurchase(&result.Hash, fundings)
+		return err
+	}
 
-		ticketPrice, err := tb.getTicketPrice()
+	funding, err := tb.sendFundingTx(outputAmounts, checkLimits, recordPurchase)
+	if err != nil {
+		return 0, err
+	}
+	if !tb.cfg.DryRun {
+		// The tickets count against the limits as soon as they are
+		// funded, including those waiting for funding confirmations.
+		spent := dcrutil.Amount(numTickets)*totalTicketCost + funding.Fee
+		err := tb.limits.recordTickets(numTickets, spent, window.index())
 		if err != nil {
-			return err
+			fmt.Printf("Recording the spending of funding transaction %s "+
+				"failed: %v\n", funding.Hash, err)
+		}
+	}
+	if purchase != nil {
+		err = tb.journal.setFundingStage(purchase, stageFundingPublished)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	fmt.Printf("Funding Tx Hash: %s\n", funding.Hash)
+	if tb.cfg.DryRun {
+		// The hash of a transaction does not commit to its signature
+		// scripts, so tickets built from the unsigned funding
+		// transaction reference the outputs it would have once signed.
+		fmt.Println("Dry run: funding transaction was not signed or published")
+		if tb.cfg.FundingConfs > 0 {
+			fmt.Printf("Dry run: tickets would be built once the funding "+
+				"transaction has %d confirmation(s)\n", tb.cfg.FundingConfs)
 		}
+	}
 
-		numAttachedBlocks := len(notificationResponse.AttachedBlocks)
-		fmt.Printf("%d block(s) attached, Ticket Price: %s\n", numAttachedBlocks, ticketPrice)
+	if waitForFunding {
+		fmt.Printf("Tickets are built once the funding transaction has %d "+
+			"confirmation(s)\n", tb.cfg.FundingConfs)
+		if tb.cfg.Daemon {
+			return numTickets, nil
+		}
 
-		err = tb.purchaseTicket()
+		err = waitForConfirmations(notifications, &funding.Hash, tb.cfg.FundingConfs)
 		if err != nil {
-			return err
+			return 0, err
 		}
+		return numTickets, tb.resumePurchases()
 	}
 
-	select {}
-}
-
-func (tb *TicketBuyer) getTicketPrice() (dcrutil.Amount, error) {
-	ctx := context.Background()
-	ticketPriceResponse, err := tb.walletService.TicketPrice(ctx, &pb.TicketPriceRequest{})
+	fundings, err := tb.ticketFundings(funding)
 	if err != nil {
 		return 0, err
 	}
+	if len(fundings) != numTickets {
+		return 0, fmt.Errorf("funding transaction funds %d tickets, expected %d",
+			len(fundings), numTickets)
+	}
+
+	results := make([]ticketResult, 0, numTickets)
+	for i, f := range fundings {
+		if f.total() != totalTicketCost || f.poolFeeAmount != poolFee {
+			return 0, fmt.Errorf("funding output %d has value %s, expected %s",
+				f.outPoint.Index, f.amount, totalTicketCost-poolFee)
+		}
+
+		var hash *chainhash.Hash
+		if purchase != nil {
+			hash, err = tb.purchaseRecordedTicket(purchase, purchase.Tickets[i],
+				f, ticketPrice)
+		} else {
+			hash, err = tb.purchaseTicket(f, ticketPrice, nil)
+		}
+		results = append(results, ticketResult{
+			fundingOutputIndex: f.outPoint.Index,
+			hash:               hash,
+			err:                err,
+		})
+	}
 
-	return dcrutil.Amount(ticketPriceResponse.TicketPrice), nil
+	var purchased int
+	for _, result := range results {
+		if result.err == nil {
+			purchased++
+		}
+	}
+	return purchased, reportTicketResults(results, tb.cfg.DryRun)
 }
 
-func (tb *TicketBuyer) purchaseTicket() error {
+// reportTicketResults prints the outcome of every ticket purchase and returns
+// an error when at least one of them failed.
+func reportTicketResults(results []ticketResult, dryRun bool) error {
+	var failed int
+	for i, result := range results {
+		if result.err != nil {
+			failed++
+			fmt.Printf("Ticket %d/%d (funding output %d): failed: %v\n", i+1,
+				len(results), result.fundingOutputIndex, result.err)
+			continue
+		}
 
-	tb.printUnspentOutputs()
-	ticketPrice, err := tb.getTicketPrice()
-	if err != nil {
-		return err
+		status := "published"
+		if dryRun {
+			status = "not published"
+		}
+		fmt.Printf("Ticket %d/%d (funding output %d): %s (%s)\n", i+1,
+			len(results), result.fundingOutputIndex, result.hash, status)
 	}
 
-	votingAddress, _, err := generateAddress(true, tb.cfg.VotingAccount, tb.netParams, tb.walletService)
-	if err != nil {
-		return err
+	if failed == 0 {
+		return nil
 	}
+	return fmt.Errorf("%d of %d ticket purchases failed", failed, len(results))
+}
 
-	estTxSize := estimateTicketSize(votingAddress)
-	ticketFee := txrules.FeeForSerializeSize(ticketFeeRelayDCR, estTxSize)
-	fmt.Printf("Ticket Price: %s, Ticket Fee: %s\n", ticketPrice, ticketFee)
-	totalTicketCost := ticketPrice + ticketFee
+// purchaseRecordedTicket purchases the journaled ticket of purchase funded by
+// funding and records its progress in the journal.
+func (tb *TicketBuyer) purchaseRecordedTicket(purchase *journalPurchase, ticket *journalTicket, funding *ticketFunding, ticketPrice dcrutil.Amount) (*chainhash.Hash, error) {
+	built := func(hash *chainhash.Hash) error {
+		return tb.journal.setTicketStage(purchase, ticket, stageTicketBuilt, hash)
+	}
 
-	fundingTx, err := tb.sendFundingTx(totalTicketCost)
+	hash, err := tb.purchaseTicket(funding, ticketPrice, built)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	fmt.Printf("Funding Tx Hash: %s\n", fundingTx.TxHash())
+	return hash, tb.journal.setTicketStage(purchase, ticket, stageTicketPublished, hash)
+}
 
-	fundingOutputIndex := -1
-	for index, output := range fundingTx.TxOut {
-		if output.Value == int64(totalTicketCost) {
-			fmt.Printf("Found ticket sized output, Value: %s\n", dcrutil.Amount(output.Value))
-			fundingOutputIndex = index
-		}
+// nextVotingAddress returns the voting address of the next ticket.  It is the
+// stakepool's voting address in pool mode, derived from the voting xpub when
+// one is configured and derived from the voting account otherwise.
+func (tb *TicketBuyer) nextVotingAddress() (dcrutil.Address, error) {
+	switch {
+	case tb.cfg.PoolMode:
+		return tb.cfg.poolVotingAddress, nil
+	case tb.votingXPub != nil:
+		return tb.votingXPub.nextAddress()
+	default:
+		votingAddress, _, err := generateAddress(true, tb.cfg.votingAccount, tb.wallet)
+		return votingAddress, err
+	}
+}
+
+// purchaseTicket builds, signs and publishes a ticket spending the funding
+// outputs of funding.  built, if set, is called with the ticket hash before the
+// ticket is signed and published.
+//
+// In pool mode the ticket votes with the stakepool's voting address and its
+// first input and commitment pay the pool fee.
+func (tb *TicketBuyer) purchaseTicket(funding *ticketFunding, ticketPrice dcrutil.Amount, built func(hash *chainhash.Hash) error) (*chainhash.Hash, error) {
+
+	votingAddress, err := tb.nextVotingAddress()
+	if err != nil {
+		return nil, err
 	}
 
-	if fundingOutputIndex == -1 {
-		return errors.New("could not find input to fund ticket transaction")
+	window, err := tb.currentStakeWindow()
+	if err != nil {
+		return nil, err
 	}
 
 	mtx := wire.NewMsgTx()
+	mtx.Expiry = tb.ticketExpiry(window)
 
-	fundingTxHash := fundingTx.TxHash()
-	txInOutpoint := wire.NewOutPoint(&fundingTxHash, uint32(fundingOutputIndex), 0)
-	txIn := wire.NewTxIn(txInOutpoint, int64(totalTicketCost), []byte{})
-	mtx.AddTxIn(txIn)
+	if funding.poolFeeOutPoint != nil {
+		mtx.AddTxIn(wire.NewTxIn(funding.poolFeeOutPoint, int64(funding.poolFeeAmount), []byte{}))
+	}
+	mtx.AddTxIn(wire.NewTxIn(funding.outPoint, int64(funding.amount), []byte{}))
 
-	fmt.Printf("Total input: %s\n", dcrutil.Amount(txIn.ValueIn))
+	fmt.Printf("Total input: %s\n", funding.total())
 
 	sstxPkScript, err := txscript.PayToSStx(votingAddress)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	sstxOut := wire.NewTxOut(int64(ticketPrice), sstxPkScript)
 	mtx.AddTxOut(sstxOut)
 
 	fmt.Printf("Total output: %s\n", dcrutil.Amount(sstxOut.Value))
 
-	sstxCommitmentAddr, _, err := generateAddress(true, tb.cfg.ChangeAccount, tb.netParams, tb.walletService)
+	if funding.poolFeeOutPoint != nil {
+		err := tb.addPoolFeeCommitment(mtx, funding.poolFeeAmount)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sstxCommitmentAddr, _, err := generateAddress(true, tb.cfg.changeAccount, tb.wallet)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	sstxCommitmentPkScript, err := txscript.GenerateSStxAddrPush(sstxCommitmentAddr, totalTicketCost, defaultTicketFeeLimits)
+	sstxCommitmentPkScript, err := txscript.GenerateSStxAddrPush(sstxCommitmentAddr, funding.amount, tb.cfg.ticketFeeLimits)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	sstxCommitmentTxOut := &wire.TxOut{
@@ -241,14 +475,14 @@ func (tb *TicketBuyer) purchaseTicket() error {
 	}
 	mtx.AddTxOut(sstxCommitmentTxOut)
 
-	sstxChangeAddr, _, err := generateAddress(true, tb.cfg.ChangeAccount, tb.netParams, tb.walletService)
+	sstxChangeAddr, _, err := generateAddress(true, tb.cfg.changeAccount, tb.wallet)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	sstxChangeScript, err := txscript.PayToSStxChange(sstxChangeAddr)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	sstxChangeTxOut := &wire.TxOut{
 		Value:    0,
@@ -260,57 +494,309 @@ func (tb *TicketBuyer) purchaseTicket() error {
 
 	if err = stake.CheckSStx(mtx); err != nil {
 		fmt.Printf("Error generate ticket transaction: %v\n", err)
-		return err
+		return nil, err
+	}
+
+	// The spending and ticket counts of funding outputs were checked and
+	// recorded together with the funding transaction, only the cost of the
+	// ticket at the current price is checked again.
+	ticketFee := funding.total() - ticketPrice
+	err = tb.limits.checkTicket(ticketPrice, ticketFee)
+	if err != nil {
+		return nil, err
+	}
+
+	if tb.cfg.DryRun {
+		err := printUnsignedTransaction(mtx, estimateTicketSize(tb.cfg.PoolMode), tb.netParams)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("Commitment fee limits: %s\n", describeFeeLimits(tb.cfg.ticketFeeLimits))
+		if tb.vsp != nil {
+			fmt.Printf("Dry run: the fee of VSP %s would be paid once the "+
+				"ticket is published\n", tb.cfg.VSPURL)
+		}
+		hash := mtx.TxHash()
+		return &hash, nil
+	}
+
+	if built != nil {
+		// The ticket hash does not commit to the signature script, so
+		// it is known before signing.
+		hash := mtx.TxHash()
+		err := built(&hash)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	serializedTx, err := mtx.Bytes()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	hash, err := signAndPublishTransaction(tb.cfg.WalletPassphrase, serializedTx, tb.walletService)
+	pass, err := tb.cfg.walletPassphrase()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	hash, err := signAndPublishTransaction(pass, serializedTx, tb.wallet)
+	zeroBytes(pass)
+	if err != nil {
+		return nil, err
 	}
 
-	fmt.Printf("Tx Hash: %s\n", hash.String())
+	if tb.votingXPub != nil {
+		err := tb.votingXPub.published()
+		if err != nil {
+			fmt.Printf("Recording the use of voting address %s failed: %v\n",
+				votingAddress, err)
+		}
+	}
 
-	return nil
+	if tb.vsp != nil {
+		// The ticket is published, a failed fee payment is retried with
+		// the other unpaid VSP tickets.
+		err := tb.addVSPTicket(hash, sstxCommitmentAddr, votingAddress)
+		if err != nil {
+			fmt.Printf("Paying the VSP fee of ticket %s failed: %v\n", hash, err)
+		}
+	}
+
+	return hash, nil
 }
 
 func (tb *TicketBuyer) printUnspentOutputs() error {
 
-	unspentOutputs, err := listUnspentOutputs(tb.cfg)
+	unspentOutputs, err := tb.listUnspentOutputs()
 	if err != nil {
 		return err
 	}
 
 	fmt.Println("Unspent Outputs")
 	for _, unspentOutput := range unspentOutputs {
-		fmt.Printf("%s:%d Spendable: %t Account: %s, Amount: %f DCR\n", unspentOutput.TxID, unspentOutput.Vout,
-			unspentOutput.Spendable, unspentOutput.Account, unspentOutput.Amount)
+		fmt.Printf("%s Account: %d, Amount: %s\n", unspentOutput.OutPoint,
+			tb.cfg.sourceAccount, unspentOutput.Amount)
 	}
 
 	return nil
 }
 
-func (tb *TicketBuyer) sendFundingTx(totalTicketCost dcrutil.Amount) (*wire.MsgTx, error) {
+// listUnspentOutputs returns the outputs of the source account available to
+// fund transactions.
+func (tb *TicketBuyer) listUnspentOutputs() ([]*unspentOutput, error) {
+	var vspTickets *vspTicketStore
+	if tb.vsp != nil {
+		vspTickets = tb.vsp.tickets
+	}
+	return unreservedOutputs(tb.cfg, tb.wallet, tb.journal, vspTickets)
+}
+
+// unreservedOutputs returns the unspent outputs of the source account with
+// the configured confirmations, excluding the funding outputs of pending
+// purchases of journal and the inputs of VSP fee transactions of vspTickets
+// that are not published yet.  vspTickets may be nil.
+func unreservedOutputs(cfg *config, wallet WalletBackend, journal *purchaseJournal, vspTickets *vspTicketStore) ([]*unspentOutput, error) {
+	ctx := context.Background()
+	utxos, err := wallet.UnspentOutputs(ctx, cfg.sourceAccount, cfg.minConf)
+	if err != nil {
+		return nil, err
+	}
+
+	reserved := journal.reserved()
+	if vspTickets != nil {
+		for outPoint := range vspTickets.reserved() {
+			reserved[outPoint] = true
+		}
+	}
+	available := utxos[:0]
+	for _, utxo := range utxos {
+		if !reserved[utxo.OutPoint] {
+			available = append(available, utxo)
+		}
+	}
+	return available, nil
+}
+
+// sendFundingTx publishes a split transaction with one output of every amount
+// of outputAmounts, paying to fresh addresses of the source account.
+// checkLimits checks the spending limits with the fee of the transaction and
+// prepared is called with the transaction before it is published.  The
+// spending is recorded by the caller.
+func (tb *TicketBuyer) sendFundingTx(outputAmounts []dcrutil.Amount, checkLimits func(fee dcrutil.Amount) error, prepared func(*regularTxResult) error) (*regularTxResult, error) {
+
+	outputs := make([]*wire.TxOut, 0, len(outputAmounts))
+	for _, amount := range outputAmounts {
+		_, outputScript, err := generateAddress(true, tb.cfg.sourceAccount, tb.wallet)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, wire.NewTxOut(int64(amount), outputScript))
+	}
 
-	_, outputScript, err := generateAddress(true, tb.cfg.SourceAccount, tb.netParams, tb.walletService)
+	_, changeScript, err := generateAddress(true, tb.cfg.sourceAccount, tb.wallet)
 	if err != nil {
 		return nil, err
 	}
 
-	_, changeScript, err := generateAddress(true, tb.cfg.SourceAccount, tb.netParams, tb.walletService)
+	utxos, err := tb.listUnspentOutputs()
 	if err != nil {
 		return nil, err
 	}
 
-	utxos, err := listUnspentOutputs(tb.cfg)
+	regularTx := NewRegularTransaction(tb.cfg, outputs, changeScript, utxos, tb.wallet)
+	regularTx.coinSelector = tb.fundingSelector
+	regularTx.maxFoldedChange = tb.cfg.fundingTolerance
+	regularTx.prepared = prepared
+	regularTx.checkLimits = checkLimits
+	funding, err := regularTx.broadcastTransaction()
 	if err != nil {
 		return nil, err
 	}
 
-	regularTx := NewRegularTransaction(tb.cfg, outputScript, changeScript, totalTicketCost, utxos, tb.walletService)
-	return regularTx.broadcastTransaction()
+	selections, matches := tb.fundingSelector.stats()
+	fmt.Printf("Funding transactions without change: %d of %d\n", matches, selections)
+
+	return funding, nil
+}
+
+// resumePurchases completes or reclaims the pending purchases of the journal,
+// which are either waiting for funding confirmations or were interrupted,
+// usually by a crash of a previous run.  A ticket is completed when its
+// funding output is still unspent, has the required confirmations and covers
+// the current ticket price and fee without overpaying the fee by more than the
+// fee itself.  Otherwise the funding output is released back to the source
+// account.
+func (tb *TicketBuyer) resumePurchases() error {
+	pending := tb.journal.pending()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if tb.cfg.DryRun {
+		fmt.Printf("Dry run: %d pending purchase(s) in %s are not resumed\n",
+			len(pending), tb.cfg.JournalFile)
+		return nil
+	}
+
+	fmt.Printf("Completing %d pending purchase(s)\n", len(pending))
+
+	ctx := context.Background()
+	utxos, err := tb.wallet.UnspentOutputs(ctx, tb.cfg.sourceAccount, 0)
+	if err != nil {
+		return err
+	}
+	unspent := make(map[wire.OutPoint]bool, len(utxos))
+	for _, utxo := range utxos {
+		unspent[utxo.OutPoint] = true
+	}
+
+	confirmed := unspent
+	if tb.cfg.FundingConfs > 0 {
+		utxos, err := tb.wallet.UnspentOutputs(ctx, tb.cfg.sourceAccount,
+			tb.cfg.FundingConfs)
+		if err != nil {
+			return err
+		}
+		confirmed = make(map[wire.OutPoint]bool, len(utxos))
+		for _, utxo := range utxos {
+			confirmed[utxo.OutPoint] = true
+		}
+	}
+
+	ticketPrice, err := tb.getTicketPrice()
+	if err != nil {
+		return err
+	}
+	ticketFee, poolFee, err := tb.ticketCosts(ticketPrice)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, purchase := range pending {
+		fundingHash, err := chainhash.NewHashFromStr(purchase.FundingHash)
+		if err != nil {
+			return fmt.Errorf("invalid funding hash in purchase journal: %v", err)
+		}
+
+		for _, ticket := range purchase.Tickets {
+			if ticket.Stage.done() {
+				continue
+			}
+
+			funding := ticket.funding(fundingHash)
+			err := tb.resumeTicket(purchase, ticket, funding, funding.in(unspent),
+				funding.in(confirmed), ticketPrice, ticketFee, poolFee)
+			if err != nil {
+				failed++
+				fmt.Printf("Resuming ticket funded by %s failed: %v\n",
+					funding.outPoint, err)
+			}
+		}
+	}
+
+	if failed != 0 {
+		return fmt.Errorf("%d pending ticket purchase(s) could not be completed", failed)
+	}
+	return nil
+}
+
+// resumeTicket completes or reclaims a pending ticket purchase funded by
+// funding.  Nothing is done while the funding outputs are unspent but not
+// confirmed.
+func (tb *TicketBuyer) resumeTicket(purchase *journalPurchase, ticket *journalTicket, funding *ticketFunding, unspent, confirmed bool, ticketPrice, ticketFee, poolFee dcrutil.Amount) error {
+	fundingOutPoint := funding.outPoint
+	if !unspent {
+		if ticket.Stage == stageTicketBuilt {
+			// The ticket may have been published before the crash,
+			// then the wallet knows it.
+			ticketHash, err := chainhash.NewHashFromStr(ticket.TicketHash)
+			if err != nil {
+				return fmt.Errorf("invalid ticket hash in purchase journal: %v", err)
+			}
+			_, err = tb.wallet.Transaction(context.Background(), ticketHash)
+			if err == nil {
+				fmt.Printf("Ticket %s funded by %s was published\n", ticketHash,
+					fundingOutPoint)
+				return tb.journal.setTicketStage(purchase, ticket, stageTicketPublished, nil)
+			}
+			fmt.Printf("Ticket %s funded by %s is not in the wallet: %v\n",
+				ticketHash, fundingOutPoint, err)
+		}
+
+		// Either the funding transaction or the ticket was never
+		// published, or the output was spent by another transaction.
+		fmt.Printf("Funding outputs of %s are not unspent, removing them from "+
+			"the journal\n", fundingOutPoint)
+		return tb.journal.setTicketStage(purchase, ticket, stageReclaimed, nil)
+	}
+
+	if !confirmed {
+		fmt.Printf("Funding output %s is waiting for %d confirmation(s)\n",
+			fundingOutPoint, tb.cfg.FundingConfs)
+		return nil
+	}
+
+	if (funding.poolFeeOutPoint != nil) != tb.cfg.PoolMode {
+		fmt.Printf("Funding outputs of %s do not match the configured pool mode, "+
+			"releasing them to the source account\n", fundingOutPoint)
+		return tb.journal.setTicketStage(purchase, ticket, stageReclaimed, nil)
+	}
+
+	ticketCost := ticketPrice + ticketFee
+	total := funding.total()
+	if total < ticketCost || total-ticketCost > ticketFee || funding.poolFeeAmount < poolFee {
+		fmt.Printf("Funding outputs of %s of %s do not match the ticket cost of %s, "+
+			"releasing them to the source account\n", fundingOutPoint, total,
+			ticketCost)
+		return tb.journal.setTicketStage(purchase, ticket, stageReclaimed, nil)
+	}
+
+	hash, err := tb.purchaseRecordedTicket(purchase, ticket, funding, ticketPrice)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Ticket %s funded by %s was published\n", hash, fundingOutPoint)
+	return nil
 }
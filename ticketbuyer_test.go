@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/dcrutil/v2"
+	"github.com/decred/dcrd/txscript/v2"
+	"github.com/decred/dcrd/wire"
+)
+
+func TestPurchaseTicket(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// underfund funds the ticket with less than its price.
+		underfund bool
+
+		// spent funds the ticket with an output that is not unspent.
+		spent bool
+
+		passphrase string
+		signErr    error
+		publishErr error
+		wantErr    bool
+	}{{
+		name: "success",
+	}, {
+		name:      "insufficient funds",
+		underfund: true,
+		wantErr:   true,
+	}, {
+		name:    "spent funding output",
+		spent:   true,
+		wantErr: true,
+	}, {
+		name:       "wrong passphrase",
+		passphrase: "wrong",
+		wantErr:    true,
+	}, {
+		name:    "sign error",
+		signErr: errors.New("sign failed"),
+		wantErr: true,
+	}, {
+		name:       "publish error",
+		publishErr: errors.New("publish failed"),
+		wantErr:    true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, cleanup := tempDir(t)
+			defer cleanup()
+
+			cfg := newTestConfig(dir)
+			if test.passphrase != "" {
+				cfg.walletPass = []byte(test.passphrase)
+			}
+			w := newTestWallet()
+			tb := newTestTicketBuyer(t, cfg, w)
+
+			ticketPrice := w.ticketPrice
+			ticketFee, _, err := tb.ticketCosts(ticketPrice)
+			if err != nil {
+				t.Fatal(err)
+			}
+			amount := ticketPrice + ticketFee
+			if test.underfund {
+				amount = ticketPrice - 1
+			}
+			err = w.addUnspentOutput(0, amount)
+			if err != nil {
+				t.Fatal(err)
+			}
+			utxos, err := w.UnspentOutputs(context.Background(), 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			funding := &ticketFunding{outPoint: &utxos[0].OutPoint, amount: amount}
+			if test.spent {
+				funding.outPoint = wire.NewOutPoint(&chainhash.Hash{1}, 0,
+					wire.TxTreeRegular)
+			}
+
+			w.signErr = test.signErr
+			w.publishErr = test.publishErr
+
+			var builtHash *chainhash.Hash
+			built := func(hash *chainhash.Hash) error {
+				builtHash = hash
+				return nil
+			}
+			hash, err := tb.purchaseTicket(funding, ticketPrice, built)
+			published := w.publishedTransactions()
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				if len(published) != 0 {
+					t.Fatalf("%d transaction(s) published after an error", len(published))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(published) != 1 {
+				t.Fatalf("published %d transactions, want 1", len(published))
+			}
+			ticket := published[0]
+			if ticket.TxHash() != *hash || builtHash == nil || *builtHash != *hash {
+				t.Fatalf("ticket hash %s, returned %v, built %v", ticket.TxHash(),
+					hash, builtHash)
+			}
+
+			if len(ticket.TxIn) != 1 || ticket.TxIn[0].PreviousOutPoint != *funding.outPoint {
+				t.Fatalf("ticket does not spend only the funding output")
+			}
+			checkTicketOutputs(t, w, ticket, ticketPrice)
+
+			window, err := tb.currentStakeWindow()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ticket.Expiry != tb.ticketExpiry(window) {
+				t.Errorf("ticket expiry %d, want %d", ticket.Expiry,
+					tb.ticketExpiry(window))
+			}
+
+			unspent, err := w.UnspentOutputs(context.Background(), 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(unspent) != 0 {
+				t.Errorf("funding output was not spent")
+			}
+		})
+	}
+}
+
+// checkTicketOutputs checks that ticket pays ticketPrice to an address of the
+// voting account followed by its commitment and an empty change output.
+func checkTicketOutputs(t *testing.T, w *fakeWallet, ticket *wire.MsgTx, ticketPrice dcrutil.Amount) {
+	t.Helper()
+
+	if len(ticket.TxOut) != 3 {
+		t.Fatalf("ticket has %d outputs, want 3", len(ticket.TxOut))
+	}
+
+	sstxOut := ticket.TxOut[0]
+	class, addrs, _, err := txscript.ExtractPkScriptAddrs(sstxOut.Version,
+		sstxOut.PkScript, w.netParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if class != txscript.StakeSubmissionTy || len(addrs) != 1 {
+		t.Fatalf("output 0 is %s, want a stake submission", class)
+	}
+	if account, ok := w.addrAccounts[addrs[0].Address()]; !ok || account != 2 {
+		t.Errorf("ticket votes with %s, not an address of the voting account", addrs[0])
+	}
+	if dcrutil.Amount(sstxOut.Value) != ticketPrice {
+		t.Errorf("ticket pays %s, want %s", dcrutil.Amount(sstxOut.Value), ticketPrice)
+	}
+
+	wantClasses := []txscript.ScriptClass{txscript.NullDataTy, txscript.StakeSubChangeTy}
+	for i, want := range wantClasses {
+		txOut := ticket.TxOut[i+1]
+		if class := txscript.GetScriptClass(txOut.Version, txOut.PkScript); class != want {
+			t.Errorf("output %d is %s, want %s", i+1, class, want)
+		}
+		if txOut.Value != 0 {
+			t.Errorf("output %d has value %d, want 0", i+1, txOut.Value)
+		}
+	}
+}
+
+func TestPurchaseTickets(t *testing.T) {
+	tests := []struct {
+		name       string
+		balance    dcrutil.Amount
+		numTickets int
+		wantErr    bool
+	}{{
+		name:       "success",
+		balance:    10 * dcrutil.AtomsPerCoin,
+		numTickets: 2,
+	}, {
+		name:       "insufficient funds",
+		balance:    dcrutil.AtomsPerCoin,
+		numTickets: 2,
+		wantErr:    true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, cleanup := tempDir(t)
+			defer cleanup()
+
+			cfg := newTestConfig(dir)
+			w := newTestWallet()
+			tb := newTestTicketBuyer(t, cfg, w)
+			err := w.addUnspentOutput(0, test.balance)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			purchased, err := tb.purchaseTickets(test.numTickets)
+			published := w.publishedTransactions()
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				if len(published) != 0 || purchased != 0 {
+					t.Fatalf("%d transaction(s) and %d ticket(s) published after "+
+						"an error", len(published), purchased)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if purchased != test.numTickets {
+				t.Fatalf("purchased %d tickets, want %d", purchased, test.numTickets)
+			}
+
+			// The funding transaction is followed by every ticket.
+			if len(published) != 1+test.numTickets {
+				t.Fatalf("published %d transactions, want %d", len(published),
+					1+test.numTickets)
+			}
+			fundingHash := published[0].TxHash()
+			for i, ticket := range published[1:] {
+				prevOut := ticket.TxIn[0].PreviousOutPoint
+				if prevOut.Hash != fundingHash || prevOut.Index != uint32(i) {
+					t.Errorf("ticket %d spends %s, want output %d of the funding "+
+						"transaction", i, prevOut, i)
+				}
+				checkTicketOutputs(t, w, ticket, w.ticketPrice)
+			}
+
+			if pending := tb.journal.pending(); len(pending) != 0 {
+				t.Errorf("%d purchase(s) still pending", len(pending))
+			}
+		})
+	}
+}
+
+func TestResumeBuiltTicket(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// published publishes the ticket before the purchase is resumed,
+		// otherwise the funding output is spent by another transaction.
+		published bool
+		wantStage purchaseStage
+	}{{
+		name:      "published",
+		published: true,
+		wantStage: stageTicketPublished,
+	}, {
+		name:      "not in wallet",
+		wantStage: stageReclaimed,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, cleanup := tempDir(t)
+			defer cleanup()
+
+			cfg := newTestConfig(dir)
+			w := newTestWallet()
+			tb := newTestTicketBuyer(t, cfg, w)
+
+			ticketFee, _, err := tb.ticketCosts(w.ticketPrice)
+			if err != nil {
+				t.Fatal(err)
+			}
+			amount := w.ticketPrice + ticketFee
+			err = w.addUnspentOutput(0, amount)
+			if err != nil {
+				t.Fatal(err)
+			}
+			utxos, err := w.UnspentOutputs(context.Background(), 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			funding := &ticketFunding{outPoint: &utxos[0].OutPoint, amount: amount}
+
+			ticketHash := &chainhash.Hash{1}
+			if test.published {
+				ticketHash, err = tb.purchaseTicket(funding, w.ticketPrice, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				w.mtx.Lock()
+				spent := w.spendOutput(*funding.outPoint)
+				w.mtx.Unlock()
+				if !spent {
+					t.Fatal("funding output not spent")
+				}
+			}
+
+			purchase, err := tb.journal.addPurchase(&funding.outPoint.Hash,
+				[]*ticketFunding{funding})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ticket := purchase.Tickets[0]
+			err = tb.journal.setTicketStage(purchase, ticket, stageTicketBuilt, ticketHash)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = tb.resumePurchases()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ticket.Stage != test.wantStage {
+				t.Errorf("ticket stage %s, want %s", ticket.Stage, test.wantStage)
+			}
+			if pending := tb.journal.pending(); len(pending) != 0 {
+				t.Errorf("%d purchase(s) still pending", len(pending))
+			}
+		})
+	}
+}
+
+func TestUnreservedOutputs(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	cfg := newTestConfig(dir)
+	w := newTestWallet()
+	for i := 0; i < 3; i++ {
+		err := w.addUnspentOutput(0, dcrutil.AtomsPerCoin)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	utxos, err := w.UnspentOutputs(context.Background(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first output funds a pending purchase.
+	journal, err := openPurchaseJournal(cfg.JournalFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	funding := &ticketFunding{outPoint: &utxos[0].OutPoint, amount: utxos[0].Amount}
+	_, err = journal.addPurchase(&utxos[0].OutPoint.Hash, []*ticketFunding{funding})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The second output is spent by a fee transaction the VSP did not
+	// publish yet.
+	feeTx := wire.NewMsgTx()
+	feeTx.AddTxIn(wire.NewTxIn(&utxos[1].OutPoint, int64(utxos[1].Amount), nil))
+	feeTxBytes, err := feeTx.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	vspTickets, err := openVSPTicketStore(filepath.Join(dir, defaultVSPTicketsFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = vspTickets.add(&vspTicket{
+		FeeTx:     hex.EncodeToString(feeTxBytes),
+		FeeStatus: vspFeeReceived,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	available, err := unreservedOutputs(cfg, w, journal, vspTickets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(available) != 1 || available[0].OutPoint != utxos[2].OutPoint {
+		t.Fatalf("%d available outputs, want only %s", len(available),
+			utxos[2].OutPoint)
+	}
+
+	// Without VSP tickets only the funding output is reserved.
+	available, err = unreservedOutputs(cfg, w, journal, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(available) != 2 {
+		t.Fatalf("%d available outputs without VSP tickets, want 2", len(available))
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// newTLSConfig returns the TLS configuration used to connect to the wallet.
+// The server certificate is verified against --rpccert and serverName, or
+// the host being dialed when serverName is empty.  The client certificate is
+// presented when one is configured.
+func newTLSConfig(cfg *config, serverName string) (*tls.Config, error) {
+	pem, err := ioutil.ReadFile(cfg.RPCCert)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if ok := pool.AppendCertsFromPEM(pem); !ok {
+		return nil, fmt.Errorf("invalid certificate file: %v", cfg.RPCCert)
+	}
+
+	tlsConfig := &tls.Config{
+		RootCAs:    pool,
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if cfg.ClientCert != "" {
+		keypair, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{keypair}
+	}
+
+	return tlsConfig, nil
+}
@@ -2,16 +2,14 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"encoding/hex"
 	"fmt"
 	"net"
 
 	"github.com/decred/dcrd/chaincfg/chainhash"
-	"github.com/decred/dcrd/dcrjson/v3"
 	"github.com/decred/dcrd/dcrutil/v2"
 	"github.com/decred/dcrd/txscript/v2"
-	wallettypes "github.com/decred/dcrwallet/rpc/jsonrpc/types"
-	pb "github.com/decred/dcrwallet/rpc/walletrpc"
+	"github.com/decred/dcrd/wire"
 	"github.com/decred/dcrwallet/wallet/v3"
 	"github.com/decred/dcrwallet/wallet/v3/txsizes"
 )
@@ -36,11 +34,20 @@ func NormalizeAddress(addr string, defaultPort string) (hostport string, err err
 	return addr, nil
 }
 
-func estimateTicketSize(votingAddress dcrutil.Address) int {
+// estimateTicketSize returns the estimated size of a signed ticket.  Tickets
+// of a legacy stakepool have an additional input and commitment paying the
+// pool fee and vote with a P2SH address.
+func estimateTicketSize(poolMode bool) int {
 
 	inSizes := []int{txsizes.RedeemP2PKHSigScriptSize}
 	outSizes := []int{txsizes.P2PKHPkScriptSize + 1,
 		txsizes.TicketCommitmentScriptSize, txsizes.P2PKHPkScriptSize + 1}
+	if poolMode {
+		inSizes = append(inSizes, txsizes.RedeemP2PKHSigScriptSize)
+		outSizes[0] = txsizes.P2SHPkScriptSize + 1
+		outSizes = append(outSizes, txsizes.TicketCommitmentScriptSize,
+			txsizes.P2PKHPkScriptSize+1)
+	}
 
 	estSize := txsizes.EstimateSerializeSizeFromScriptSizes(inSizes, outSizes, 0)
 	fmt.Printf("Estimated Ticket Size: %d\n", estSize)
@@ -61,24 +68,9 @@ func addressScript(addr dcrutil.Address) (pkScript []byte, version uint16, err e
 	}
 }
 
-func generateAddress(internal bool, accountNumber uint32, net dcrutil.AddressParams, walletService pb.WalletServiceClient) (address dcrutil.Address, pkScript []byte, err error) {
+func generateAddress(internal bool, accountNumber uint32, wallet WalletBackend) (address dcrutil.Address, pkScript []byte, err error) {
 	ctx := context.Background()
-	addressRequest := &pb.NextAddressRequest{
-		Account:   accountNumber,
-		Kind:      pb.NextAddressRequest_BIP0044_EXTERNAL,
-		GapPolicy: pb.NextAddressRequest_GAP_POLICY_WRAP,
-	}
-
-	if internal {
-		addressRequest.Kind = pb.NextAddressRequest_BIP0044_INTERNAL
-	}
-
-	addressResponse, err := walletService.NextAddress(ctx, addressRequest)
-	if err != nil {
-		return
-	}
-
-	address, err = dcrutil.DecodeAddress(addressResponse.Address, net)
+	address, err = wallet.NextAddress(ctx, accountNumber, internal)
 	if err != nil {
 		return
 	}
@@ -87,55 +79,77 @@ func generateAddress(internal bool, accountNumber uint32, net dcrutil.AddressPar
 	return
 }
 
-func listUnspentOutputs(cfg *config) ([]wallettypes.ListUnspentResult, error) {
-	minConfs := requiredConfirmations
-	unspentCmd := wallettypes.NewListUnspentCmd(&minConfs, nil, nil)
-	marshalledJSON, err := dcrjson.MarshalCmd(rpcVersion, 1, unspentCmd)
-	if err != nil {
-		return nil, err
-	}
-
-	resp, err := sendPostRequest(cfg.RPCServer, cfg.RPCUser, cfg.RPCPass, marshalledJSON)
+func signAndPublishTransaction(walletPassphrase []byte, serializedTx []byte, wallet WalletBackend) (hash *chainhash.Hash, err error) {
+	signedTx, err := signTransaction(walletPassphrase, serializedTx, wallet)
 	if err != nil {
-		return nil, err
+		return
 	}
 
-	var unspentOutputs []wallettypes.ListUnspentResult
-	err = json.Unmarshal(resp.Result, &unspentOutputs)
-	if err != nil {
-		return nil, err
-	}
+	return publishTransaction(signedTx, wallet)
+}
 
-	return unspentOutputs, nil
+func signTransaction(walletPassphrase []byte, serializedTx []byte, wallet WalletBackend) ([]byte, error) {
+	ctx := context.Background()
+	return wallet.SignTransaction(ctx, walletPassphrase, serializedTx)
 }
 
-func signAndPublishTransaction(walletPassphrase string, serializedTx []byte, walletService pb.WalletServiceClient) (hash *chainhash.Hash, err error) {
+func publishTransaction(signedTx []byte, wallet WalletBackend) (hash *chainhash.Hash, err error) {
 	ctx := context.Background()
-	signTransactionRequest := &pb.SignTransactionRequest{
-		Passphrase:            []byte(walletPassphrase),
-		SerializedTransaction: serializedTx,
-	}
 
-	signTransactionResponse, err := walletService.SignTransaction(ctx, signTransactionRequest)
+	hash, err = wallet.PublishTransaction(ctx, signedTx)
 	if err != nil {
 		return
 	}
 
-	publishTransactionRequest := &pb.PublishTransactionRequest{
-		SignedTransaction: signTransactionResponse.Transaction,
-	}
+	fmt.Println("Transaction published")
 
-	publishTransactionResponse, err := walletService.PublishTransaction(ctx, publishTransactionRequest)
+	return
+}
+
+// printUnsignedTransaction prints the serialized transaction along with its
+// decoded inputs and outputs, the estimated signed size and the fee.  It is
+// used to review transactions in dry-run mode instead of signing and
+// publishing them.
+func printUnsignedTransaction(mtx *wire.MsgTx, estSignedSize int, net dcrutil.AddressParams) error {
+	serializedTx, err := mtx.Bytes()
 	if err != nil {
-		return
+		return err
 	}
 
-	fmt.Println("Transaction published")
+	fmt.Printf("Unsigned transaction %s\n", mtx.TxHash())
+	fmt.Printf("Hex: %s\n", hex.EncodeToString(serializedTx))
 
-	hash, err = chainhash.NewHash(publishTransactionResponse.TransactionHash)
-	if err != nil {
-		return
+	var totalIn, totalOut dcrutil.Amount
+	fmt.Println("Inputs:")
+	for i, txIn := range mtx.TxIn {
+		totalIn += dcrutil.Amount(txIn.ValueIn)
+		fmt.Printf("  %d: %s Amount: %s\n", i, txIn.PreviousOutPoint,
+			dcrutil.Amount(txIn.ValueIn))
 	}
 
-	return
+	fmt.Println("Outputs:")
+	for i, txOut := range mtx.TxOut {
+		totalOut += dcrutil.Amount(txOut.Value)
+		scriptClass, addrs, _, err := txscript.ExtractPkScriptAddrs(txOut.Version,
+			txOut.PkScript, net)
+		if err != nil {
+			return err
+		}
+
+		addrStrs := make([]string, 0, len(addrs))
+		for _, addr := range addrs {
+			addrStrs = append(addrStrs, addr.Address())
+		}
+		fmt.Printf("  %d: %s Amount: %s Addresses: %v\n", i, scriptClass,
+			dcrutil.Amount(txOut.Value), addrStrs)
+	}
+
+	fee := totalIn - totalOut
+	fmt.Printf("Estimated Signed Size: %d bytes, Fee: %s (%s/kB)\n", estSignedSize,
+		fee, fee*1000/dcrutil.Amount(estSignedSize))
+	if mtx.Expiry != wire.NoExpiryValue {
+		fmt.Printf("Expiry: height %d\n", mtx.Expiry)
+	}
+
+	return nil
 }
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/decred/dcrd/chaincfg/v2"
+	"github.com/decred/dcrd/dcrec"
+	"github.com/decred/dcrd/dcrutil/v2"
+	"github.com/decred/dcrd/hdkeychain/v2"
+)
+
+const defaultVotingXPubFilename = "votingxpub.json"
+
+// xpubGapLimit is the default gap limit of dcrwallet.  The voting wallet does
+// not discover addresses further than this past the last used one.
+const xpubGapLimit = 20
+
+// xpubState is the derivation state of an extended public key kept on disk.
+// Unused is the number of addresses derived since the last address used by a
+// published ticket.
+type xpubState struct {
+	XPub      string `json:"xpub"`
+	NextIndex uint32 `json:"nextindex"`
+	Unused    uint32 `json:"unused,omitempty"`
+}
+
+// xpubVotingAddresses derives voting addresses from the account extended
+// public key of a separate voting wallet.  Addresses are derived from the
+// external branch of the account, the same addresses the voting wallet
+// derives, and the index of the next address is persisted so no address is
+// used twice.  In dry run mode the state is not persisted.
+type xpubVotingAddresses struct {
+	branch *hdkeychain.ExtendedKey
+	params *chaincfg.Params
+	path   string
+	dryRun bool
+	state  xpubState
+}
+
+// newXPubVotingAddresses returns the voting address source of the account
+// extended public key xpub, continuing at the index recorded in the state file
+// at path.
+func newXPubVotingAddresses(xpub, path string, dryRun bool, params *chaincfg.Params) (*xpubVotingAddresses, error) {
+	key, err := hdkeychain.NewKeyFromString(xpub, params)
+	if err != nil {
+		return nil, fmt.Errorf("invalid votingxpub: %v", err)
+	}
+	if key.IsPrivate() {
+		return nil, fmt.Errorf("votingxpub must be an extended public key")
+	}
+
+	branch, err := key.Child(0)
+	if err != nil {
+		return nil, err
+	}
+
+	x := &xpubVotingAddresses{
+		branch: branch,
+		params: params,
+		path:   path,
+		dryRun: dryRun,
+		state:  xpubState{XPub: xpub},
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return x, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var state xpubState
+	err = json.Unmarshal(b, &state)
+	if err != nil {
+		return nil, fmt.Errorf("invalid voting xpub state %s: %v", path, err)
+	}
+	if state.XPub != xpub {
+		// The state of a different key can not be continued.
+		fmt.Printf("Voting xpub changed, deriving voting addresses from index 0\n")
+		return x, nil
+	}
+	x.state = state
+	return x, nil
+}
+
+// nextAddress derives the next unused voting address.  The index is persisted
+// before the address is returned.  No address is derived once the addresses
+// derived since the last published ticket reach the gap limit, the voting
+// wallet would not discover the tickets of further addresses.
+func (x *xpubVotingAddresses) nextAddress() (dcrutil.Address, error) {
+	if x.state.Unused >= xpubGapLimit {
+		return nil, fmt.Errorf("%d voting addresses were derived without "+
+			"publishing a ticket, further addresses are beyond the gap limit "+
+			"of %d of the voting wallet, remove the unused count of %s after "+
+			"the voting wallet rescanned with a larger gap limit",
+			x.state.Unused, xpubGapLimit, x.path)
+	}
+
+	for {
+		index := x.state.NextIndex
+		if index >= hdkeychain.HardenedKeyStart {
+			return nil, fmt.Errorf("voting xpub has no unused addresses left")
+		}
+
+		child, err := x.branch.Child(index)
+		x.state.NextIndex++
+		if err == hdkeychain.ErrInvalidChild {
+			// Skip the rare indexes without a valid key.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		x.state.Unused++
+		err = x.save()
+		if err != nil {
+			return nil, err
+		}
+
+		pkHash := dcrutil.Hash160(child.SerializedPubKey())
+		return dcrutil.NewAddressPubKeyHash(pkHash, x.params, dcrec.STEcdsaSecp256k1)
+	}
+}
+
+// published records that the last derived address is used by a published
+// ticket.
+func (x *xpubVotingAddresses) published() error {
+	x.state.Unused = 0
+	return x.save()
+}
+
+// save persists the derivation state unless in dry run mode.
+func (x *xpubVotingAddresses) save() error {
+	if x.dryRun {
+		return nil
+	}
+	return writeJSONFile(x.path, &x.state)
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/dcrutil/v2"
+	"github.com/decred/dcrd/wire"
+)
+
+const (
+	defaultVSPTicketsFilename = "vsptickets.json"
+	defaultVSPPubKeysFilename = "vsppubkeys.json"
+)
+
+// vspFeeStatus is the status of the VSP fee of a ticket.  Apart from
+// vspFeeUnpaid the statuses are the fee transaction statuses reported by the
+// VSP.
+type vspFeeStatus string
+
+const (
+	// vspFeeUnpaid means the fee was not paid yet, or the VSP does not
+	// know about the payment.
+	vspFeeUnpaid vspFeeStatus = "unpaid"
+
+	// vspFeeReceived means the VSP received the fee transaction and will
+	// publish it once the ticket is confirmed.
+	vspFeeReceived vspFeeStatus = "received"
+
+	// vspFeeBroadcast means the VSP published the fee transaction.
+	vspFeeBroadcast vspFeeStatus = "broadcast"
+
+	// vspFeeConfirmed means the fee transaction is confirmed and the VSP
+	// votes the ticket.
+	vspFeeConfirmed vspFeeStatus = "confirmed"
+
+	// vspFeeError means the VSP failed to publish the fee transaction and
+	// the fee must be paid again.
+	vspFeeError vspFeeStatus = "error"
+)
+
+// vspTicket is the assignment of a ticket to a VSP.
+type vspTicket struct {
+	TicketHash        string         `json:"tickethash"`
+	VSP               string         `json:"vsp"`
+	CommitmentAddress string         `json:"commitmentaddress"`
+	VotingAddress     string         `json:"votingaddress"`
+	FeeAddress        string         `json:"feeaddress,omitempty"`
+	FeeAmount         dcrutil.Amount `json:"feeamount,omitempty"`
+	FeeTxHash         string         `json:"feetxhash,omitempty"`
+	FeeTx             string         `json:"feetx,omitempty"`
+	FeeStatus         vspFeeStatus   `json:"feestatus"`
+	Updated           time.Time      `json:"updated"`
+}
+
+// vspTicketStore records the VSP assignment of every ticket purchased in VSP
+// mode in a JSON file.
+type vspTicketStore struct {
+	path    string
+	tickets []*vspTicket
+}
+
+// openVSPTicketStore loads the store at path.  A missing file is an empty
+// store.
+func openVSPTicketStore(path string) (*vspTicketStore, error) {
+	s := &vspTicketStore{path: path}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, &s.tickets)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vsp ticket file %s: %v", path, err)
+	}
+	return s, nil
+}
+
+// add records ticket.
+func (s *vspTicketStore) add(ticket *vspTicket) error {
+	s.tickets = append(s.tickets, ticket)
+	return s.save()
+}
+
+// pending returns the tickets whose fee is not confirmed.
+func (s *vspTicketStore) pending() []*vspTicket {
+	var pending []*vspTicket
+	for _, ticket := range s.tickets {
+		if ticket.FeeStatus != vspFeeConfirmed {
+			pending = append(pending, ticket)
+		}
+	}
+	return pending
+}
+
+// reserved returns the outputs spent by fee transactions the VSP received but
+// did not publish yet.  The wallet does not know about these transactions,
+// so their inputs must not be spent by anything else.
+func (s *vspTicketStore) reserved() map[wire.OutPoint]bool {
+	outPoints := make(map[wire.OutPoint]bool)
+	for _, ticket := range s.tickets {
+		if ticket.FeeStatus != vspFeeReceived {
+			continue
+		}
+
+		b, err := hex.DecodeString(ticket.FeeTx)
+		if err != nil {
+			continue
+		}
+		var feeTx wire.MsgTx
+		if err := feeTx.FromBytes(b); err != nil {
+			continue
+		}
+		for _, txIn := range feeTx.TxIn {
+			outPoints[txIn.PreviousOutPoint] = true
+		}
+	}
+	return outPoints
+}
+
+// save writes the store to its file.
+func (s *vspTicketStore) save() error {
+	return writeJSONFile(s.path, s.tickets)
+}
+
+// pinnedVSPPubKey returns the public key pinned for the VSP at url in the file
+// at path, or nil when none was pinned.
+func pinnedVSPPubKey(path, url string) (ed25519.PublicKey, error) {
+	var pubKeys map[string][]byte
+	err := readJSONFile(path, &pubKeys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vsp public key file %s: %v", path, err)
+	}
+	return pubKeys[url], nil
+}
+
+// pinVSPPubKey records pubKey as the public key of the VSP at url in the file
+// at path.
+func pinVSPPubKey(path, url string, pubKey ed25519.PublicKey) error {
+	var pubKeys map[string][]byte
+	err := readJSONFile(path, &pubKeys)
+	if err != nil {
+		return fmt.Errorf("invalid vsp public key file %s: %v", path, err)
+	}
+	if pubKeys == nil {
+		pubKeys = make(map[string][]byte)
+	}
+	pubKeys[url] = pubKey
+	return writeJSONFile(path, pubKeys)
+}
+
+// vspManager pays the fees of tickets voted by a VSP and tracks their status.
+type vspManager struct {
+	client  *vspClient
+	tickets *vspTicketStore
+	maxFee  dcrutil.Amount
+}
+
+// newVSPManager connects to the configured VSP and checks that it accepts
+// tickets of the active network.  Without --vsppubkey the public key first
+// reported by the VSP is pinned and a different key is refused later on.
+func newVSPManager(ctx context.Context, cfg *config) (*vspManager, error) {
+	pinned, err := pinnedVSPPubKey(cfg.vspPubKeysFile, cfg.VSPURL)
+	if err != nil {
+		return nil, err
+	}
+	pubKey := cfg.vspPubKey
+	if pubKey == nil {
+		pubKey = pinned
+	}
+
+	client := newVSPClient(cfg.VSPURL, pubKey, cfg.RPCTimeout)
+	info, err := client.info(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("vsp info: %v", err)
+	}
+
+	if info.Network != cfg.params.Name {
+		return nil, fmt.Errorf("vsp %s is on network %s, not %s", cfg.VSPURL,
+			info.Network, cfg.params.Name)
+	}
+	if info.VSPClosed {
+		return nil, fmt.Errorf("vsp %s is closed to new tickets", cfg.VSPURL)
+	}
+	fmt.Printf("Using VSP %s, fee: %.2f%%\n", cfg.VSPURL, info.FeePercentage)
+
+	if !bytes.Equal(client.pubKey, pinned) && !cfg.DryRun {
+		err := pinVSPPubKey(cfg.vspPubKeysFile, cfg.VSPURL, client.pubKey)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("Pinned the public key %s of VSP %s\n",
+			base64.StdEncoding.EncodeToString(client.pubKey), cfg.VSPURL)
+	}
+
+	tickets, err := openVSPTicketStore(cfg.vspTicketsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &vspManager{
+		client:  client,
+		tickets: tickets,
+		maxFee:  cfg.vspMaxFee,
+	}, nil
+}
+
+// addVSPTicket assigns the published ticket with hash ticketHash to the VSP and
+// pays its fee.
+func (tb *TicketBuyer) addVSPTicket(ticketHash *chainhash.Hash, commitmentAddr, votingAddr dcrutil.Address) error {
+	ticket := &vspTicket{
+		TicketHash:        ticketHash.String(),
+		VSP:               tb.cfg.VSPURL,
+		CommitmentAddress: commitmentAddr.Address(),
+		VotingAddress:     votingAddr.Address(),
+		FeeStatus:         vspFeeUnpaid,
+		Updated:           time.Now(),
+	}
+	err := tb.vsp.tickets.add(ticket)
+	if err != nil {
+		return err
+	}
+
+	return tb.payVSPFee(ticket)
+}
+
+// processVSPTickets pays the fees that are unpaid or need to be paid again and
+// updates the status of every other ticket whose fee is not confirmed.
+func (tb *TicketBuyer) processVSPTickets() error {
+	if tb.vsp == nil {
+		return nil
+	}
+
+	pending := tb.vsp.tickets.pending()
+	if tb.cfg.DryRun {
+		if len(pending) != 0 {
+			fmt.Printf("Dry run: %d ticket(s) with unconfirmed VSP fees are not "+
+				"processed\n", len(pending))
+		}
+		return nil
+	}
+
+	var failed int
+	for _, ticket := range pending {
+		var err error
+		switch ticket.FeeStatus {
+		case vspFeeUnpaid, vspFeeError:
+			err = tb.payVSPFee(ticket)
+		default:
+			err = tb.updateVSPTicketStatus(ticket)
+		}
+		if err != nil {
+			failed++
+			fmt.Printf("VSP ticket %s: %v\n", ticket.TicketHash, err)
+		}
+	}
+
+	if failed != 0 {
+		return fmt.Errorf("%d of %d VSP ticket(s) could not be processed", failed,
+			len(pending))
+	}
+	return nil
+}
+
+// vspSigner returns a messageSigner signing with the commitment address of
+// ticket.
+func (tb *TicketBuyer) vspSigner(ticket *vspTicket) (messageSigner, error) {
+	commitmentAddr, err := dcrutil.DecodeAddress(ticket.CommitmentAddress, tb.netParams)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context, message string) ([]byte, error) {
+		pass, err := tb.cfg.walletPassphrase()
+		if err != nil {
+			return nil, err
+		}
+		defer zeroBytes(pass)
+		return tb.wallet.SignMessage(ctx, commitmentAddr, message, pass)
+	}, nil
+}
+
+// payVSPFee requests the fee of ticket from the VSP, builds and signs a fee
+// transaction paying it from the source account and hands it to the VSP along
+// with the voting key.  The VSP publishes the fee transaction.
+func (tb *TicketBuyer) payVSPFee(ticket *vspTicket) error {
+	ctx := context.Background()
+
+	sign, err := tb.vspSigner(ticket)
+	if err != nil {
+		return err
+	}
+
+	ticketHash, err := chainhash.NewHashFromStr(ticket.TicketHash)
+	if err != nil {
+		return err
+	}
+	ticketTx, err := tb.wallet.Transaction(ctx, ticketHash)
+	if err != nil {
+		return fmt.Errorf("ticket: %v", err)
+	}
+	var ticketMsgTx wire.MsgTx
+	err = ticketMsgTx.FromBytes(ticketTx)
+	if err != nil {
+		return err
+	}
+	parentTx, err := tb.wallet.Transaction(ctx, &ticketMsgTx.TxIn[0].PreviousOutPoint.Hash)
+	if err != nil {
+		return fmt.Errorf("ticket funding transaction: %v", err)
+	}
+
+	feeAddressResponse, err := tb.vsp.client.feeAddress(ctx, &feeAddressRequest{
+		Timestamp:  time.Now().Unix(),
+		TicketHash: ticket.TicketHash,
+		TicketHex:  hex.EncodeToString(ticketTx),
+		ParentHex:  hex.EncodeToString(parentTx),
+	}, sign)
+	if err != nil {
+		return fmt.Errorf("fee address: %v", err)
+	}
+
+	feeAmount := dcrutil.Amount(feeAddressResponse.FeeAmount)
+	if feeAmount <= 0 || feeAmount > tb.vsp.maxFee {
+		return fmt.Errorf("vsp fee of %s is not within (0, %s]", feeAmount,
+			tb.vsp.maxFee)
+	}
+	feeAddr, err := dcrutil.DecodeAddress(feeAddressResponse.FeeAddress, tb.netParams)
+	if err != nil {
+		return fmt.Errorf("fee address: %v", err)
+	}
+	feeScript, _, err := addressScript(feeAddr)
+	if err != nil {
+		return err
+	}
+
+	_, changeScript, err := generateAddress(true, tb.cfg.sourceAccount, tb.wallet)
+	if err != nil {
+		return err
+	}
+	utxos, err := tb.listUnspentOutputs()
+	if err != nil {
+		return err
+	}
+
+	outputs := []*wire.TxOut{wire.NewTxOut(int64(feeAmount), feeScript)}
+	feeTx := NewRegularTransaction(tb.cfg, outputs, changeScript, utxos, tb.wallet)
+	feeTx.signOnly = true
+	feeTx.limits = tb.limits
+	feeResult, err := feeTx.broadcastTransaction()
+	if err != nil {
+		return fmt.Errorf("fee transaction: %v", err)
+	}
+
+	votingAddr, err := dcrutil.DecodeAddress(ticket.VotingAddress, tb.netParams)
+	if err != nil {
+		return err
+	}
+	pass, err := tb.cfg.walletPassphrase()
+	if err != nil {
+		return err
+	}
+	votingKey, err := tb.wallet.DumpPrivKey(ctx, votingAddr, pass)
+	zeroBytes(pass)
+	if err != nil {
+		return fmt.Errorf("voting key: %v", err)
+	}
+
+	err = tb.vsp.client.payFee(ctx, &payFeeRequest{
+		Timestamp:   time.Now().Unix(),
+		TicketHash:  ticket.TicketHash,
+		FeeTx:       hex.EncodeToString(feeResult.SignedTx),
+		VotingKey:   votingKey,
+		VoteChoices: map[string]string{},
+	}, sign)
+	if err != nil {
+		return fmt.Errorf("pay fee: %v", err)
+	}
+
+	// The VSP publishes the fee transaction, it is spent once the VSP
+	// accepted it.
+	err = tb.limits.recordTransaction(feeAmount, feeResult.Fee)
+	if err != nil {
+		fmt.Printf("Recording the spending of VSP fee transaction %s failed: %v\n",
+			feeResult.Hash, err)
+	}
+
+	ticket.FeeAddress = feeAddressResponse.FeeAddress
+	ticket.FeeAmount = feeAmount
+	ticket.FeeTxHash = feeResult.Hash.String()
+	ticket.FeeTx = hex.EncodeToString(feeResult.SignedTx)
+	ticket.FeeStatus = vspFeeReceived
+	ticket.Updated = time.Now()
+	fmt.Printf("Paid VSP fee of %s for ticket %s with transaction %s\n", feeAmount,
+		ticket.TicketHash, ticket.FeeTxHash)
+
+	return tb.vsp.tickets.save()
+}
+
+// updateVSPTicketStatus updates the fee status of ticket from the VSP.
+func (tb *TicketBuyer) updateVSPTicketStatus(ticket *vspTicket) error {
+	sign, err := tb.vspSigner(ticket)
+	if err != nil {
+		return err
+	}
+
+	status, err := tb.vsp.client.ticketStatus(context.Background(),
+		&ticketStatusRequest{TicketHash: ticket.TicketHash}, sign)
+	if err != nil {
+		return fmt.Errorf("ticket status: %v", err)
+	}
+
+	feeStatus := vspFeeStatus(status.FeeTxStatus)
+	switch feeStatus {
+	case vspFeeReceived, vspFeeBroadcast, vspFeeConfirmed, vspFeeError:
+	default:
+		// The VSP does not know about a fee payment.
+		feeStatus = vspFeeUnpaid
+	}
+	if feeStatus == ticket.FeeStatus {
+		return nil
+	}
+
+	fmt.Printf("VSP ticket %s: fee status %s -> %s\n", ticket.TicketHash,
+		ticket.FeeStatus, feeStatus)
+	ticket.FeeStatus = feeStatus
+	ticket.Updated = time.Now()
+	return tb.vsp.tickets.save()
+}
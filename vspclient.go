@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// vspClientSigHeader holds the signature of a request body by the
+	// commitment address of the ticket it is about.
+	vspClientSigHeader = "VSP-Client-Signature"
+
+	// vspServerSigHeader holds the signature of a response body by the
+	// VSP's ed25519 key.
+	vspServerSigHeader = "VSP-Server-Signature"
+)
+
+// vspError is an error response of the VSP.
+type vspError struct {
+	HTTPStatus int    `json:"-"`
+	Code       int    `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (e *vspError) Error() string {
+	return fmt.Sprintf("vsp error %d (%d %s): %s", e.Code, e.HTTPStatus,
+		http.StatusText(e.HTTPStatus), e.Message)
+}
+
+type vspInfoResponse struct {
+	APIVersions   []int64 `json:"apiversions"`
+	Timestamp     int64   `json:"timestamp"`
+	PubKey        []byte  `json:"pubkey"`
+	FeePercentage float64 `json:"feepercentage"`
+	VSPClosed     bool    `json:"vspclosed"`
+	Network       string  `json:"network"`
+}
+
+type feeAddressRequest struct {
+	Timestamp  int64  `json:"timestamp"`
+	TicketHash string `json:"tickethash"`
+	TicketHex  string `json:"tickethex"`
+	ParentHex  string `json:"parenthex"`
+}
+
+type feeAddressResponse struct {
+	Timestamp  int64  `json:"timestamp"`
+	FeeAddress string `json:"feeaddress"`
+	FeeAmount  int64  `json:"feeamount"`
+	Expiration int64  `json:"expiration"`
+}
+
+type payFeeRequest struct {
+	Timestamp   int64             `json:"timestamp"`
+	TicketHash  string            `json:"tickethash"`
+	FeeTx       string            `json:"feetx"`
+	VotingKey   string            `json:"votingkey"`
+	VoteChoices map[string]string `json:"votechoices"`
+}
+
+type ticketStatusRequest struct {
+	TicketHash string `json:"tickethash"`
+}
+
+type ticketStatusResponse struct {
+	Timestamp       int64  `json:"timestamp"`
+	TicketConfirmed bool   `json:"ticketconfirmed"`
+	FeeTxStatus     string `json:"feetxstatus"`
+	FeeTxHash       string `json:"feetxhash"`
+}
+
+// messageSigner signs message with the commitment address of a ticket.
+type messageSigner func(ctx context.Context, message string) ([]byte, error)
+
+// vspClient talks to the HTTP API of a vspd compatible voting service
+// provider.  Every response is verified against the VSP's public key.
+type vspClient struct {
+	url        string
+	pubKey     ed25519.PublicKey
+	httpClient *http.Client
+}
+
+func newVSPClient(url string, pubKey ed25519.PublicKey, timeout time.Duration) *vspClient {
+	return &vspClient{
+		url:        strings.TrimSuffix(url, "/"),
+		pubKey:     pubKey,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+// info returns the VSP's information.  When the client has no public key the
+// key reported by the VSP is trusted from then on, otherwise the reported key
+// must match it.
+func (c *vspClient) info(ctx context.Context) (*vspInfoResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.url+"/api/v3/vspinfo", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var info vspInfoResponse
+	err = json.Unmarshal(body.data, &info)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.pubKey == nil {
+		if len(info.PubKey) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("vsp reported an invalid public key")
+		}
+		c.pubKey = info.PubKey
+	} else if !bytes.Equal(info.PubKey, c.pubKey) {
+		return nil, fmt.Errorf("vsp reported the public key %s instead of %s",
+			base64.StdEncoding.EncodeToString(info.PubKey),
+			base64.StdEncoding.EncodeToString(c.pubKey))
+	}
+
+	err = c.verify(body)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// feeAddress requests the fee address and amount of a ticket.
+func (c *vspClient) feeAddress(ctx context.Context, request *feeAddressRequest, sign messageSigner) (*feeAddressResponse, error) {
+	var response feeAddressResponse
+	err := c.post(ctx, "/api/v3/feeaddress", request, sign, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// payFee submits the signed fee transaction and voting key of a ticket.  The
+// VSP publishes the fee transaction itself.
+func (c *vspClient) payFee(ctx context.Context, request *payFeeRequest, sign messageSigner) error {
+	return c.post(ctx, "/api/v3/payfee", request, sign, nil)
+}
+
+// ticketStatus returns the VSP's status of a ticket.
+func (c *vspClient) ticketStatus(ctx context.Context, request *ticketStatusRequest, sign messageSigner) (*ticketStatusResponse, error) {
+	var response ticketStatusResponse
+	err := c.post(ctx, "/api/v3/ticketstatus", request, sign, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// post sends request signed by sign to path and unmarshals the verified
+// response into response, which may be nil when it is not needed.
+func (c *vspClient) post(ctx context.Context, path string, request interface{}, sign messageSigner, response interface{}) error {
+	requestBody, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	signature, err := sign(ctx, string(requestBody))
+	if err != nil {
+		return fmt.Errorf("sign vsp request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url+path,
+		bytes.NewReader(requestBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(vspClientSigHeader, base64.StdEncoding.EncodeToString(signature))
+
+	body, err := c.send(req)
+	if err != nil {
+		return err
+	}
+
+	err = c.verify(body)
+	if err != nil {
+		return err
+	}
+
+	if response == nil {
+		return nil
+	}
+	return json.Unmarshal(body.data, response)
+}
+
+// vspResponse is the body of a successful response and its signature.
+type vspResponse struct {
+	data      []byte
+	signature string
+}
+
+// send sends req and returns the body of a successful response.  Error
+// responses are returned as *vspError.
+func (c *vspClient) send(req *http.Request) (*vspResponse, error) {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		vspErr := &vspError{HTTPStatus: resp.StatusCode}
+		if err := json.Unmarshal(body, vspErr); err != nil {
+			vspErr.Message = strings.TrimSpace(string(body))
+		}
+		return nil, vspErr
+	}
+
+	return &vspResponse{
+		data:      body,
+		signature: resp.Header.Get(vspServerSigHeader),
+	}, nil
+}
+
+// verify checks the signature of a response against the VSP's public key.
+func (c *vspClient) verify(resp *vspResponse) error {
+	signature, err := base64.StdEncoding.DecodeString(resp.signature)
+	if err != nil {
+		return fmt.Errorf("invalid vsp response signature: %v", err)
+	}
+
+	if !ed25519.Verify(c.pubKey, resp.data, signature) {
+		return fmt.Errorf("vsp response signature does not match the vsp public key")
+	}
+	return nil
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrd/dcrutil/v2"
+)
+
+// fakeVSP is a vspd compatible VSP signing its responses with privKey.
+type fakeVSP struct {
+	mtx sync.Mutex
+
+	privKey    ed25519.PrivateKey
+	network    string
+	feeAddress string
+	feeAmount  dcrutil.Amount
+
+	// badSignature signs responses with a key other than privKey.
+	badSignature bool
+
+	// payFeeErrs is the number of payfee requests that are rejected.
+	payFeeErrs int
+
+	// payFees are the accepted payfee requests.
+	payFees []*payFeeRequest
+}
+
+// newFakeVSP starts a fake VSP on network.  The returned server must be
+// closed.
+func newFakeVSP(t *testing.T, network string) (*fakeVSP, *httptest.Server) {
+	t.Helper()
+
+	vsp := &fakeVSP{
+		privKey: newTestVSPKey(t),
+		network: network,
+	}
+	return vsp, httptest.NewServer(vsp)
+}
+
+func newTestVSPKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+
+	_, privKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return privKey
+}
+
+func (v *fakeVSP) pubKey() ed25519.PublicKey {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+
+	return v.privKey.Public().(ed25519.PublicKey)
+}
+
+func (v *fakeVSP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+
+	if r.Method == "POST" && r.Header.Get(vspClientSigHeader) == "" {
+		v.writeError(w, http.StatusUnauthorized, "missing client signature")
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		v.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	switch r.URL.Path {
+	case "/api/v3/vspinfo":
+		v.write(w, &vspInfoResponse{
+			APIVersions: []int64{3},
+			Timestamp:   time.Now().Unix(),
+			PubKey:      v.privKey.Public().(ed25519.PublicKey),
+			Network:     v.network,
+		})
+
+	case "/api/v3/feeaddress":
+		v.write(w, &feeAddressResponse{
+			Timestamp:  time.Now().Unix(),
+			FeeAddress: v.feeAddress,
+			FeeAmount:  int64(v.feeAmount),
+			Expiration: time.Now().Add(time.Hour).Unix(),
+		})
+
+	case "/api/v3/payfee":
+		if v.payFeeErrs > 0 {
+			v.payFeeErrs--
+			v.writeError(w, http.StatusBadRequest, "fee transaction rejected")
+			return
+		}
+		var request payFeeRequest
+		err := json.Unmarshal(body, &request)
+		if err != nil {
+			v.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		v.payFees = append(v.payFees, &request)
+		v.write(w, struct {
+			Timestamp int64 `json:"timestamp"`
+		}{time.Now().Unix()})
+
+	case "/api/v3/ticketstatus":
+		var request ticketStatusRequest
+		err := json.Unmarshal(body, &request)
+		if err != nil {
+			v.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		response := &ticketStatusResponse{Timestamp: time.Now().Unix()}
+		for _, payFee := range v.payFees {
+			if payFee.TicketHash == request.TicketHash {
+				response.FeeTxStatus = string(vspFeeReceived)
+			}
+		}
+		v.write(w, response)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// write writes the signed JSON response v.
+func (v *fakeVSP) write(w http.ResponseWriter, response interface{}) {
+	b, err := json.Marshal(response)
+	if err != nil {
+		v.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	privKey := v.privKey
+	if v.badSignature {
+		_, privKey, _ = ed25519.GenerateKey(nil)
+	}
+	w.Header().Set(vspServerSigHeader,
+		base64.StdEncoding.EncodeToString(ed25519.Sign(privKey, b)))
+	w.Write(b)
+}
+
+func (v *fakeVSP) writeError(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&vspError{Code: status, Message: message})
+}
+
+func TestVSPClientInfo(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// badSignature signs responses with another key.
+		badSignature bool
+
+		// otherKey configures the client with a key the VSP does not
+		// sign with.
+		otherKey bool
+
+		// noKey trusts the key reported by the VSP.
+		noKey   bool
+		wantErr bool
+	}{{
+		name: "valid signature",
+	}, {
+		name:  "trusted key",
+		noKey: true,
+	}, {
+		name:         "bad signature",
+		badSignature: true,
+		wantErr:      true,
+	}, {
+		name:         "bad signature of trusted key",
+		badSignature: true,
+		noKey:        true,
+		wantErr:      true,
+	}, {
+		name:     "other key",
+		otherKey: true,
+		wantErr:  true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vsp, server := newFakeVSP(t, simNetParams.Name)
+			defer server.Close()
+			vsp.badSignature = test.badSignature
+
+			pubKey := vsp.pubKey()
+			switch {
+			case test.noKey:
+				pubKey = nil
+			case test.otherKey:
+				pubKey = newTestVSPKey(t).Public().(ed25519.PublicKey)
+			}
+
+			client := newVSPClient(server.URL, pubKey, 5*time.Second)
+			info, err := client.info(context.Background())
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Network != simNetParams.Name {
+				t.Errorf("network %s, want %s", info.Network, simNetParams.Name)
+			}
+			if !bytes.Equal(client.pubKey, vsp.pubKey()) {
+				t.Errorf("client does not use the key of the VSP")
+			}
+		})
+	}
+}
+
+func TestVSPPubKeyPinning(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	vsp, server := newFakeVSP(t, simNetParams.Name)
+	defer server.Close()
+
+	cfg := newTestVSPConfig(dir, server.URL)
+	_, err := newVSPManager(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned, err := pinnedVSPPubKey(cfg.vspPubKeysFile, cfg.VSPURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pinned, vsp.pubKey()) {
+		t.Fatal("the key of the VSP was not pinned")
+	}
+
+	// A VSP changing its key is refused.
+	vsp.mtx.Lock()
+	vsp.privKey = newTestVSPKey(t)
+	vsp.mtx.Unlock()
+	_, err = newVSPManager(context.Background(), cfg)
+	if err == nil {
+		t.Fatal("expected an error for a changed VSP key")
+	}
+
+	// Configuring the new key replaces the pinned one.
+	cfg.vspPubKey = vsp.pubKey()
+	_, err = newVSPManager(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned, err = pinnedVSPPubKey(cfg.vspPubKeysFile, cfg.VSPURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pinned, vsp.pubKey()) {
+		t.Fatal("the configured key was not pinned")
+	}
+}
+
+// newTestVSPConfig returns the configuration of tests purchasing tickets voted
+// by the VSP at url, keeping every file in dir.
+func newTestVSPConfig(dir, url string) *config {
+	cfg := newTestConfig(dir)
+	cfg.VSPURL = url
+	cfg.RPCTimeout = 5 * time.Second
+	cfg.vspMaxFee = dcrutil.AtomsPerCoin / 10
+	cfg.vspTicketsFile = filepath.Join(dir, defaultVSPTicketsFilename)
+	cfg.vspPubKeysFile = filepath.Join(dir, defaultVSPPubKeysFilename)
+	return cfg
+}
+
+func TestPayVSPFee(t *testing.T) {
+	tests := []struct {
+		name       string
+		feeAmount  dcrutil.Amount
+		payFeeErrs int
+
+		// wantStatus is the fee status after the purchase and after
+		// processing the pending VSP tickets once.
+		wantStatus      vspFeeStatus
+		wantRetryStatus vspFeeStatus
+		wantPayFees     int
+	}{{
+		name:            "paid",
+		feeAmount:       1e6,
+		wantStatus:      vspFeeReceived,
+		wantRetryStatus: vspFeeReceived,
+		wantPayFees:     1,
+	}, {
+		name:            "fee above vspmaxfee",
+		feeAmount:       dcrutil.AtomsPerCoin/10 + 1,
+		wantStatus:      vspFeeUnpaid,
+		wantRetryStatus: vspFeeUnpaid,
+	}, {
+		name:            "retry after payfee error",
+		feeAmount:       1e6,
+		payFeeErrs:      1,
+		wantStatus:      vspFeeUnpaid,
+		wantRetryStatus: vspFeeReceived,
+		wantPayFees:     1,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, cleanup := tempDir(t)
+			defer cleanup()
+
+			vsp, server := newFakeVSP(t, simNetParams.Name)
+			defer server.Close()
+
+			cfg := newTestVSPConfig(dir, server.URL)
+			w := newTestWallet()
+			tb := newTestTicketBuyer(t, cfg, w)
+
+			// The fee transaction is only signed, so the fee may as
+			// well pay to the wallet.
+			feeAddr, err := w.NextAddress(context.Background(), 2, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			vsp.feeAddress = feeAddr.Address()
+			vsp.feeAmount = test.feeAmount
+			vsp.payFeeErrs = test.payFeeErrs
+
+			tb.vsp, err = newVSPManager(context.Background(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = w.addUnspentOutput(0, 10*dcrutil.AtomsPerCoin)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = tb.purchaseTickets(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tickets := tb.vsp.tickets.tickets
+			if len(tickets) != 1 {
+				t.Fatalf("%d VSP tickets, want 1", len(tickets))
+			}
+			ticket := tickets[0]
+			if ticket.FeeStatus != test.wantStatus {
+				t.Fatalf("fee status %s, want %s", ticket.FeeStatus, test.wantStatus)
+			}
+
+			err = tb.processVSPTickets()
+			if (err != nil) != (test.wantRetryStatus == vspFeeUnpaid) {
+				t.Fatalf("processing VSP tickets: %v", err)
+			}
+			if ticket.FeeStatus != test.wantRetryStatus {
+				t.Fatalf("fee status %s after retrying, want %s", ticket.FeeStatus,
+					test.wantRetryStatus)
+			}
+
+			if len(vsp.payFees) != test.wantPayFees {
+				t.Fatalf("VSP accepted %d fee payments, want %d", len(vsp.payFees),
+					test.wantPayFees)
+			}
+
+			// The fee is spent once the VSP accepts it, no matter how
+			// often it was signed.
+			var feeSpendings int
+			for _, r := range tb.limits.records {
+				if r.Tickets == 0 && r.Amount > test.feeAmount {
+					feeSpendings++
+				}
+			}
+			if feeSpendings != test.wantPayFees {
+				t.Fatalf("recorded %d VSP fee spendings, want %d", feeSpendings,
+					test.wantPayFees)
+			}
+			if test.wantPayFees == 0 {
+				if ticket.FeeTx != "" {
+					t.Errorf("fee transaction recorded without a payment")
+				}
+				return
+			}
+			payFee := vsp.payFees[0]
+			if payFee.TicketHash != ticket.TicketHash || payFee.FeeTx != ticket.FeeTx {
+				t.Errorf("VSP received fee %s of ticket %s, want %s of %s",
+					payFee.FeeTx, payFee.TicketHash, ticket.FeeTx, ticket.TicketHash)
+			}
+			if payFee.VotingKey != "fakewif-"+ticket.VotingAddress {
+				t.Errorf("VSP received voting key %s of the wrong address",
+					payFee.VotingKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/decred/dcrd/chaincfg/chainhash"
+	"github.com/decred/dcrd/dcrutil/v2"
+	chainjson "github.com/decred/dcrd/rpc/jsonrpc/types"
+	"github.com/decred/dcrd/wire"
+	wallettypes "github.com/decred/dcrwallet/rpc/jsonrpc/types"
+	pb "github.com/decred/dcrwallet/rpc/walletrpc"
+)
+
+// unspentOutput is an unspent wallet output that can fund a transaction.
+type unspentOutput struct {
+	OutPoint wire.OutPoint
+	Amount   dcrutil.Amount
+	PkScript []byte
+}
+
+// walletQuerier describes the wallet queries that are answered by either the
+// gRPC or the JSON-RPC API of the wallet.
+type walletQuerier interface {
+	// UnspentOutputs returns the spendable outputs of account with at
+	// least minConf confirmations.
+	UnspentOutputs(ctx context.Context, account uint32, minConf int32) ([]*unspentOutput, error)
+
+	// TicketRelayFee returns the fee rate per kB used for tickets.
+	TicketRelayFee(ctx context.Context) (dcrutil.Amount, error)
+
+	// TxRelayFee returns the fee rate per kB used for regular
+	// transactions.
+	TxRelayFee(ctx context.Context) (dcrutil.Amount, error)
+
+	// DumpPrivKey returns the WIF encoded private key of address.  Only
+	// the JSON-RPC API exports private keys, so dumping the voting keys
+	// handed to a VSP requires JSON-RPC.
+	DumpPrivKey(ctx context.Context, address dcrutil.Address, passphrase []byte) (string, error)
+
+	// EstimateStakeDiff returns the estimated ticket price of the next
+	// stake difficulty window.
+	EstimateStakeDiff(ctx context.Context) (*stakeDiffEstimate, error)
+}
+
+// newWalletQuerier returns the querier for the configured API.  Only the
+// gRPC API is used with --grpconly, otherwise queries are made over JSON-RPC.
+func newWalletQuerier(cfg *config, walletService pb.WalletServiceClient) (walletQuerier, error) {
+	if cfg.GRPCOnly {
+		return newGRPCQuerier(walletService, cfg.ticketFee, cfg.txFee), nil
+	}
+
+	rpcClient, err := newJSONRPCClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	accountNames := map[uint32]string{
+		cfg.sourceAccount: cfg.sourceAccountName,
+	}
+	return newJSONRPCQuerier(rpcClient, accountNames), nil
+}
+
+// grpcQuerier answers wallet queries using only the gRPC API.  The gRPC API
+// does not expose the wallet's relay fees, so the configured fee rates are
+// used instead.
+type grpcQuerier struct {
+	walletService pb.WalletServiceClient
+	ticketFee     dcrutil.Amount
+	txFee         dcrutil.Amount
+}
+
+func newGRPCQuerier(walletService pb.WalletServiceClient, ticketFee, txFee dcrutil.Amount) *grpcQuerier {
+	return &grpcQuerier{
+		walletService: walletService,
+		ticketFee:     ticketFee,
+		txFee:         txFee,
+	}
+}
+
+func (q *grpcQuerier) UnspentOutputs(ctx context.Context, account uint32, minConf int32) ([]*unspentOutput, error) {
+	// The wallet streams outputs until their sum reaches the target
+	// amount, so request the maximum amount to receive every output.
+	unspentRequest := &pb.UnspentOutputsRequest{
+		Account:               account,
+		TargetAmount:          int64(dcrutil.MaxAmount),
+		RequiredConfirmations: minConf,
+	}
+	unspentClient, err := q.walletService.UnspentOutputs(ctx, unspentRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	var unspentOutputs []*unspentOutput
+	for {
+		unspentResponse, err := unspentClient.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		txHash, err := chainhash.NewHash(unspentResponse.TransactionHash)
+		if err != nil {
+			return nil, err
+		}
+
+		unspentOutputs = append(unspentOutputs, &unspentOutput{
+			OutPoint: *wire.NewOutPoint(txHash, unspentResponse.OutputIndex,
+				int8(unspentResponse.Tree)),
+			Amount:   dcrutil.Amount(unspentResponse.Amount),
+			PkScript: unspentResponse.PkScript,
+		})
+	}
+
+	return unspentOutputs, nil
+}
+
+func (q *grpcQuerier) TicketRelayFee(ctx context.Context) (dcrutil.Amount, error) {
+	return q.ticketFee, nil
+}
+
+func (q *grpcQuerier) TxRelayFee(ctx context.Context) (dcrutil.Amount, error) {
+	return q.txFee, nil
+}
+
+func (q *grpcQuerier) DumpPrivKey(ctx context.Context, address dcrutil.Address, passphrase []byte) (string, error) {
+	return "", fmt.Errorf("private keys can not be exported with --grpconly")
+}
+
+func (q *grpcQuerier) EstimateStakeDiff(ctx context.Context) (*stakeDiffEstimate, error) {
+	return nil, fmt.Errorf("stake difficulty can not be estimated with --grpconly")
+}
+
+// jsonRPCQuerier answers wallet queries using the JSON-RPC API.
+type jsonRPCQuerier struct {
+	rpcClient *jsonRPCClient
+
+	// accountNames maps account numbers to the names listunspent reports
+	// outputs with.
+	accountNames map[uint32]string
+}
+
+func newJSONRPCQuerier(rpcClient *jsonRPCClient, accountNames map[uint32]string) *jsonRPCQuerier {
+	return &jsonRPCQuerier{
+		rpcClient:    rpcClient,
+		accountNames: accountNames,
+	}
+}
+
+func (q *jsonRPCQuerier) UnspentOutputs(ctx context.Context, account uint32, minConf int32) ([]*unspentOutput, error) {
+	accountName, ok := q.accountNames[account]
+	if !ok {
+		return nil, fmt.Errorf("no account name known for account %d", account)
+	}
+
+	minConfs := int(minConf)
+	unspentCmd := wallettypes.NewListUnspentCmd(&minConfs, nil, nil)
+
+	var unspentResults []wallettypes.ListUnspentResult
+	err := q.rpcClient.call(ctx, unspentCmd, &unspentResults)
+	if err != nil {
+		return nil, err
+	}
+
+	var unspentOutputs []*unspentOutput
+	for _, result := range unspentResults {
+		if !result.Spendable || result.Account != accountName {
+			continue
+		}
+
+		amount, err := dcrutil.NewAmount(result.Amount)
+		if err != nil {
+			return nil, err
+		}
+
+		txHash, err := chainhash.NewHashFromStr(result.TxID)
+		if err != nil {
+			return nil, err
+		}
+
+		pkScript, err := hex.DecodeString(result.ScriptPubKey)
+		if err != nil {
+			return nil, err
+		}
+
+		unspentOutputs = append(unspentOutputs, &unspentOutput{
+			OutPoint: *wire.NewOutPoint(txHash, result.Vout, result.Tree),
+			Amount:   amount,
+			PkScript: pkScript,
+		})
+	}
+
+	return unspentOutputs, nil
+}
+
+func (q *jsonRPCQuerier) TicketRelayFee(ctx context.Context) (dcrutil.Amount, error) {
+	var relayFee float64
+	err := q.rpcClient.call(ctx, wallettypes.NewGetTicketFeeCmd(), &relayFee)
+	if err != nil {
+		return 0, err
+	}
+
+	return dcrutil.NewAmount(relayFee)
+}
+
+func (q *jsonRPCQuerier) TxRelayFee(ctx context.Context) (dcrutil.Amount, error) {
+	var relayFee float64
+	err := q.rpcClient.call(ctx, wallettypes.NewGetWalletFeeCmd(), &relayFee)
+	if err != nil {
+		return 0, err
+	}
+
+	return dcrutil.NewAmount(relayFee)
+}
+
+// dumpPrivKeyUnlockTimeout is the number of seconds the wallet is unlocked for
+// to export a private key.  The wallet is locked again right after.
+const dumpPrivKeyUnlockTimeout = 10
+
+// DumpPrivKey exports the key with dumpprivkey, which needs an unlocked
+// wallet.  A locked wallet is unlocked with passphrase for the export and
+// locked again afterwards, a wallet that is already unlocked is left
+// unlocked.
+func (q *jsonRPCQuerier) DumpPrivKey(ctx context.Context, address dcrutil.Address, passphrase []byte) (string, error) {
+	var info wallettypes.WalletInfoResult
+	err := q.rpcClient.call(ctx, wallettypes.NewWalletInfoCmd(), &info)
+	if err != nil {
+		return "", err
+	}
+
+	if !info.Unlocked {
+		// The JSON-RPC API takes the passphrase as a string, which can
+		// not be cleared after use.
+		unlockCmd := wallettypes.NewWalletPassphraseCmd(string(passphrase),
+			dumpPrivKeyUnlockTimeout)
+		err := q.rpcClient.call(ctx, unlockCmd, nil)
+		if err != nil {
+			return "", err
+		}
+		defer func() {
+			err := q.rpcClient.call(ctx, wallettypes.NewWalletLockCmd(), nil)
+			if err != nil {
+				fmt.Printf("Failed to lock wallet: %v\n", err)
+			}
+		}()
+	}
+
+	var wif string
+	err = q.rpcClient.call(ctx, wallettypes.NewDumpPrivKeyCmd(address.Address()), &wif)
+	if err != nil {
+		return "", err
+	}
+	return wif, nil
+}
+
+// EstimateStakeDiff requests the estimate of the consensus daemon, which the
+// wallet answers when it is connected to one over RPC.
+func (q *jsonRPCQuerier) EstimateStakeDiff(ctx context.Context) (*stakeDiffEstimate, error) {
+	var result chainjson.EstimateStakeDiffResult
+	err := q.rpcClient.call(ctx, chainjson.NewEstimateStakeDiffCmd(nil), &result)
+	if err != nil {
+		return nil, err
+	}
+
+	min, err := dcrutil.NewAmount(result.Min)
+	if err != nil {
+		return nil, err
+	}
+	expected, err := dcrutil.NewAmount(result.Expected)
+	if err != nil {
+		return nil, err
+	}
+	max, err := dcrutil.NewAmount(result.Max)
+	if err != nil {
+		return nil, err
+	}
+
+	estimate := stakeDiffEstimate{Min: min, Expected: expected, Max: max}
+	return &estimate, nil
+}